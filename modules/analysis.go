@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Move quality annotations
+const (
+	AnnotationBest       = "best"       // Keeps the best outcome available
+	AnnotationInaccuracy = "inaccuracy" // Lets a forced win slip to a draw
+	AnnotationBlunder    = "blunder"    // Turns a win or draw into a forced loss
+)
+
+// Outcomes of a position for the player to move, assuming perfect play
+const (
+	EvaluationWin  = "win"
+	EvaluationDraw = "draw"
+	EvaluationLoss = "loss"
+)
+
+// GetGameAnalysisRequest represents a request to analyze a finished game
+type GetGameAnalysisRequest struct {
+	MatchID string `json:"match_id"`
+}
+
+// MoveEvaluation grades a single move against perfect play
+type MoveEvaluation struct {
+	MoveNumber int    `json:"move_number"` // 1-based
+	Player     string `json:"player"`
+	Move       Move   `json:"move"`
+	Before     string `json:"before"` // Best outcome the mover could force before moving
+	After      string `json:"after"`  // Outcome the mover can force after this move
+	Annotation string `json:"annotation"`
+	Comment    string `json:"comment,omitempty"`
+	BestMove   Move   `json:"best_move"` // Optimal move in the position
+}
+
+// GameAnalysis is the perfect-play review of a finished game
+type GameAnalysis struct {
+	MatchID string           `json:"match_id"`
+	Variant string           `json:"variant"`
+	Result  GameResult       `json:"result"`
+	Moves   []MoveEvaluation `json:"moves"`
+}
+
+// RpcGetGameAnalysis solves every position of a finished game and grades each move
+func RpcGetGameAnalysis(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var request GetGameAnalysisRequest
+	if err := json.Unmarshal([]byte(payload), &request); err != nil {
+		logger.Error("Failed to unmarshal request: %v", err)
+		return "", runtime.NewError("invalid request payload", 3)
+	}
+
+	if request.MatchID == "" {
+		return "", runtime.NewError("match_id is required", 3)
+	}
+
+	gameID, err := ResolveGameID(ctx, nk, request.MatchID)
+	if err != nil {
+		logger.Error("Failed to resolve current game: %v", err)
+		return "", runtime.NewError("failed to load game state", 13)
+	}
+
+	gameState, err := LoadGameState(ctx, nk, gameID)
+	if err != nil {
+		logger.Error("Failed to load game state: %v", err)
+		return "", runtime.NewError("game not found", 5)
+	}
+
+	if gameState.Status != GameStatusFinished {
+		return "", runtime.NewError("game is not finished", 9)
+	}
+	if !CanAnalyze(gameState) {
+		return "", runtime.NewError("analysis is only available for 3x3 games", 9)
+	}
+	if len(gameState.Moves) == 0 {
+		return "", runtime.NewError("game has no move history", 9)
+	}
+
+	analysis, err := AnalyzeGame(gameState)
+	if err != nil {
+		logger.Error("Failed to analyze game %s: %v", request.MatchID, err)
+		return "", runtime.NewError("failed to analyze game", 13)
+	}
+
+	responseJSON, err := json.Marshal(analysis)
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// CanAnalyze reports whether a game's variant is small enough to solve completely
+func CanAnalyze(gs *GameState) bool {
+	return gs.BoardVariant().SearchDepth == 0
+}
+
+// AnalyzeGame replays the move history from an empty board, solving each
+// position to compare the move played with the best one available
+func AnalyzeGame(gs *GameState) (*GameAnalysis, error) {
+	variant := BoardVariant{Name: gs.Variant, Size: gs.BoardSize, WinLength: gs.WinLength}
+	replay := NewGameState(gs.MatchID, gs.PlayerX, gs.PlayerO, gs.GameMode, variant)
+	rules := replay.Rules()
+	search := newBotSearch(replay, rules)
+
+	analysis := &GameAnalysis{
+		MatchID: gs.MatchID,
+		Variant: gs.Variant,
+		Result:  gs.Result,
+		Moves:   make([]MoveEvaluation, 0, len(gs.Moves)),
+	}
+
+	for i, record := range gs.Moves {
+		if replay.Status != GameStatusActive {
+			break
+		}
+
+		// Solve every alternative, keeping the first of the best
+		bestMove := Move{}
+		bestScore := -botWinScore - 1
+		for _, move := range rules.LegalMoves(replay) {
+			if score := search.solveMove(replay, move); score > bestScore {
+				bestMove, bestScore = move, score
+			}
+		}
+
+		move := record.Move()
+		if err := rules.ValidateMove(replay, move); err != nil {
+			return nil, fmt.Errorf("move %d does not replay: %w", i+1, err)
+		}
+		played := search.solveMove(replay, move)
+
+		evaluation := MoveEvaluation{
+			MoveNumber: i + 1,
+			Player:     record.Player,
+			Move:       move,
+			Before:     evaluationOf(bestScore),
+			After:      evaluationOf(played),
+			BestMove:   bestMove,
+		}
+		evaluation.Annotation, evaluation.Comment = annotate(evaluation.Before, evaluation.After)
+		analysis.Moves = append(analysis.Moves, evaluation)
+
+		if err := replay.PlayMove(move, record.Player, record.Time(), record.Tick); err != nil {
+			return nil, fmt.Errorf("move %d does not replay: %w", i+1, err)
+		}
+	}
+
+	return analysis, nil
+}
+
+// solveMove returns the exact score of a move for the player to move
+func (s *botSearch) solveMove(gs *GameState, move Move) int {
+	mover := gs.CurrentPlayer
+
+	next := gs.Clone()
+	next.advance(s.rules, move)
+	if next.Status == GameStatusFinished {
+		return outcomeScore(next, mover)
+	}
+
+	// Deep enough to reach the end of any game on the board
+	depth := gs.BoardSize*gs.BoardSize + 1
+	return -s.negamax(next, depth, -botWinScore-1, botWinScore+1)
+}
+
+// evaluationOf turns a search score into the outcome it forces
+func evaluationOf(score int) string {
+	switch {
+	case score > 0:
+		return EvaluationWin
+	case score < 0:
+		return EvaluationLoss
+	default:
+		return EvaluationDraw
+	}
+}
+
+// annotate grades a move by how much of the best outcome it gave away
+func annotate(before, after string) (string, string) {
+	switch {
+	case before == after:
+		return AnnotationBest, ""
+	case before == EvaluationWin && after == EvaluationDraw:
+		return AnnotationInaccuracy, "this move turned a forced win into a draw"
+	case before == EvaluationWin:
+		return AnnotationBlunder, "this move turned a forced win into a loss"
+	default:
+		return AnnotationBlunder, "this move turned a drawn position into a loss"
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeGameAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		moves       []Move
+		annotations []string
+		wantComment string // Comment on the last move
+		wantBest    Move   // Best move in the position before the last move
+	}{
+		{
+			name: "accurate play to a draw",
+			moves: []Move{
+				{Row: 1, Col: 1}, {Row: 0, Col: 0}, {Row: 2, Col: 2},
+				{Row: 0, Col: 2}, {Row: 0, Col: 1}, {Row: 2, Col: 1},
+			},
+			annotations: []string{AnnotationBest, AnnotationBest, AnnotationBest, AnnotationBest, AnnotationBest, AnnotationBest},
+			wantBest:    Move{Row: 2, Col: 1},
+		},
+		{
+			name:        "drawn position thrown away",
+			moves:       []Move{{Row: 0, Col: 0}, {Row: 0, Col: 1}},
+			annotations: []string{AnnotationBest, AnnotationBlunder},
+			wantComment: "this move turned a drawn position into a loss",
+			wantBest:    Move{Row: 1, Col: 1},
+		},
+		{
+			name:        "forced win let slip to a draw",
+			moves:       []Move{{Row: 0, Col: 0}, {Row: 0, Col: 1}, {Row: 2, Col: 2}},
+			annotations: []string{AnnotationBest, AnnotationBlunder, AnnotationInaccuracy},
+			wantComment: "this move turned a forced win into a draw",
+			wantBest:    Move{Row: 1, Col: 0},
+		},
+		{
+			name: "forced win turned into a loss",
+			moves: []Move{
+				{Row: 0, Col: 0}, {Row: 0, Col: 1}, {Row: 0, Col: 2},
+				{Row: 1, Col: 0}, {Row: 2, Col: 0},
+			},
+			annotations: []string{AnnotationBest, AnnotationBlunder, AnnotationInaccuracy, AnnotationBlunder, AnnotationBlunder},
+			wantComment: "this move turned a forced win into a loss",
+			wantBest:    Move{Row: 1, Col: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGame(VariantClassic)
+			playMoves(t, gs, tt.moves...)
+
+			analysis, err := AnalyzeGame(gs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(analysis.Moves) != len(tt.annotations) {
+				t.Fatalf("got %d evaluations, want %d", len(analysis.Moves), len(tt.annotations))
+			}
+			for i, evaluation := range analysis.Moves {
+				if evaluation.Annotation != tt.annotations[i] {
+					t.Errorf("move %d: got %s (%s -> %s), want %s",
+						i+1, evaluation.Annotation, evaluation.Before, evaluation.After, tt.annotations[i])
+				}
+			}
+
+			last := analysis.Moves[len(analysis.Moves)-1]
+			if last.Comment != tt.wantComment {
+				t.Errorf("last move comment %q, want %q", last.Comment, tt.wantComment)
+			}
+			if last.BestMove != tt.wantBest {
+				t.Errorf("last move best %+v, want %+v", last.BestMove, tt.wantBest)
+			}
+		})
+	}
+}
+
+func TestAnalyzeGameRejectsBrokenHistory(t *testing.T) {
+	gs := newTestGame(VariantClassic)
+	playMoves(t, gs, Move{Row: 1, Col: 1}, Move{Row: 0, Col: 0})
+	gs.Moves[1].Row, gs.Moves[1].Col = 1, 1
+
+	if _, err := AnalyzeGame(gs); err == nil || !strings.Contains(err.Error(), "move 2 does not replay") {
+		t.Fatalf("expected the replay to fail on move 2, got %v", err)
+	}
+}
+
+func TestCanAnalyze(t *testing.T) {
+	tests := []struct {
+		variant string
+		want    bool
+	}{
+		{VariantClassic, true},
+		{VariantMisere, true},
+		{VariantWild, true},
+		{"4x4", false},
+		{"gomoku", false},
+		{VariantUltimate, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanAnalyze(newTestGame(tt.variant)); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.variant, got, tt.want)
+		}
+	}
+}
@@ -23,10 +23,19 @@ type AuthenticateDeviceResponse struct {
 
 // UserProfile represents user game statistics
 type UserProfile struct {
+	Wins   int        `json:"wins"` // Ranked record, alongside Rating
+	Losses int        `json:"losses"`
+	Draws  int        `json:"draws"`
+	Rating int        `json:"rating"`
+	Casual GameRecord `json:"casual"` // Unrated games
+	VsBot  GameRecord `json:"vs_bot"` // Queue games backfilled with a bot
+}
+
+// GameRecord tracks wins, losses and draws for games that don't affect rating
+type GameRecord struct {
 	Wins   int `json:"wins"`
 	Losses int `json:"losses"`
 	Draws  int `json:"draws"`
-	Rating int `json:"rating"`
 }
 
 // RpcAuthenticateDevice handles device-based authentication
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math/rand"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// BotUserID occupies the seat of the server-side bot in a game
+const BotUserID = "bot"
+
+// Bot difficulty levels
+const (
+	BotDifficultyRandom    = "random"    // Any legal move
+	BotDifficultyHeuristic = "heuristic" // Wins or blocks when it can, otherwise plays centrally
+	BotDifficultyPerfect   = "perfect"   // Minimax with alpha-beta pruning
+	BotDifficultyMatched   = "matched"   // Plays to approximate GameState.BotRating, used for queue backfill
+)
+
+// BotThinkTicks is how long the bot waits before moving, in match ticks
+const BotThinkTicks = 5
+
+// botWinScore bounds search scores; a win scores botWinScore less the moves it took
+const botWinScore = 1000
+
+// PlayVsBotRequest represents a request to start a game against the bot
+type PlayVsBotRequest struct {
+	Difficulty string `json:"difficulty"`        // "random", "heuristic" (default) or "perfect"
+	Variant    string `json:"variant,omitempty"` // Board variant, defaults to classic
+	Symbol     string `json:"symbol,omitempty"`  // "X", "O" or empty for a coin flip
+}
+
+// PlayVsBotResponse represents the created bot match
+type PlayVsBotResponse struct {
+	MatchID    string       `json:"match_id"`
+	Symbol     PlayerSymbol `json:"symbol"`
+	Difficulty string       `json:"difficulty"`
+	Variant    string       `json:"variant"`
+}
+
+// RpcPlayVsBot creates an authoritative match against the server-side bot.
+// Bot games are always casual.
+func RpcPlayVsBot(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	var request PlayVsBotRequest
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &request); err != nil {
+			logger.Error("Failed to unmarshal request: %v", err)
+			return "", runtime.NewError("invalid request payload", 3)
+		}
+	}
+
+	if request.Difficulty == "" {
+		request.Difficulty = BotDifficultyHeuristic
+	}
+	// The matched bot needs a rating to play to, which only queue backfill supplies
+	if request.Difficulty == BotDifficultyMatched || !ValidBotDifficulty(request.Difficulty) {
+		return "", runtime.NewError("invalid difficulty, must be 'random', 'heuristic' or 'perfect'", 3)
+	}
+
+	variant, err := LookupVariant(request.Variant, 0)
+	if err != nil {
+		return "", runtime.NewError("invalid variant", 3)
+	}
+
+	symbol := PlayerSymbol(request.Symbol)
+	playerX, playerO, firstMove := userID, BotUserID, FirstMoveChosen
+	switch symbol {
+	case SymbolX:
+	case SymbolO:
+		playerX, playerO = BotUserID, userID
+	case SymbolEmpty:
+		x, o, policy := AssignSymbols(ctx, logger, nk, MatchPlayer{UserID: userID}, MatchPlayer{UserID: BotUserID})
+		playerX, playerO, firstMove = x.UserID, o.UserID, policy
+		symbol = SymbolX
+		if playerO == userID {
+			symbol = SymbolO
+		}
+	default:
+		return "", runtime.NewError("invalid symbol, must be 'X' or 'O'", 3)
+	}
+
+	matchID, err := nk.MatchCreate(ctx, "tictactoe", map[string]interface{}{
+		"player1":        playerX,
+		"player2":        playerO,
+		"game_mode":      GameModeCasual,
+		"variant":        variant.Name,
+		"bot_difficulty": request.Difficulty,
+		"first_move":     firstMove,
+	})
+	if err != nil {
+		logger.Error("Failed to create bot match: %v", err)
+		return "", runtime.NewError("failed to create match", 13)
+	}
+
+	logger.Info("Created bot match - ID: %s, Player: %s, Symbol: %s, Difficulty: %s", matchID, userID, symbol, request.Difficulty)
+
+	responseJSON, err := json.Marshal(PlayVsBotResponse{
+		MatchID:    matchID,
+		Symbol:     symbol,
+		Difficulty: request.Difficulty,
+		Variant:    variant.Name,
+	})
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// ValidBotDifficulty reports whether a difficulty level exists
+func ValidBotDifficulty(difficulty string) bool {
+	switch difficulty {
+	case BotDifficultyRandom, BotDifficultyHeuristic, BotDifficultyPerfect, BotDifficultyMatched:
+		return true
+	}
+	return false
+}
+
+// IsBotUser reports whether a seat is held by the bot
+func IsBotUser(userID string) bool {
+	return userID == BotUserID
+}
+
+// ChooseBotMove picks the bot's move for the player to move. It reports
+// false when there is no legal move.
+func ChooseBotMove(gs *GameState, difficulty string) (Move, bool) {
+	rules := gs.Rules()
+	moves := candidateMoves(gs, rules)
+	if len(moves) == 0 {
+		return Move{}, false
+	}
+
+	if difficulty == BotDifficultyMatched {
+		difficulty = matchedDifficulty(gs.BotRating)
+	}
+
+	switch difficulty {
+	case BotDifficultyRandom:
+		return moves[rand.Intn(len(moves))], true
+	case BotDifficultyPerfect:
+		return perfectMove(gs, rules, moves), true
+	default:
+		return heuristicMove(gs, rules, moves), true
+	}
+}
+
+// matchedDifficulty picks the difficulty of a single move so the bot plays
+// roughly at the given rating: at 600 and below every move is random, at
+// 1400 and above every move is perfect, and in between the levels are mixed.
+func matchedDifficulty(rating int) string {
+	skill := float64(rating-600) / 800
+	switch roll := rand.Float64(); {
+	case roll < skill:
+		return BotDifficultyPerfect
+	case roll < 2*skill:
+		return BotDifficultyHeuristic
+	default:
+		return BotDifficultyRandom
+	}
+}
+
+// heuristicMove wins at once if it can, avoids moves that hand the opponent
+// an immediate win, and otherwise prefers the centre
+func heuristicMove(gs *GameState, rules Rules, moves []Move) Move {
+	mover := gs.CurrentPlayer
+
+	safe := make([]Move, 0, len(moves))
+	for _, move := range moves {
+		next := gs.Clone()
+		next.advance(rules, move)
+
+		if next.Status == GameStatusFinished {
+			if next.WinnerSymbol() == mover {
+				return move
+			}
+			if next.Result == GameResultDraw {
+				safe = append(safe, move)
+			}
+			continue
+		}
+		if !hasWinningMove(next, rules) {
+			safe = append(safe, move)
+		}
+	}
+
+	if len(safe) == 0 {
+		safe = moves
+	}
+	return centralMove(gs, safe)
+}
+
+// hasWinningMove reports whether the player to move can win with their next move
+func hasWinningMove(gs *GameState, rules Rules) bool {
+	mover := gs.CurrentPlayer
+	for _, move := range candidateMoves(gs, rules) {
+		next := gs.Clone()
+		next.advance(rules, move)
+		if next.Status == GameStatusFinished && next.WinnerSymbol() == mover {
+			return true
+		}
+	}
+	return false
+}
+
+// centralMove returns the move closest to the centre of its board, breaking ties at random
+func centralMove(gs *GameState, moves []Move) Move {
+	size := gs.BoardSize
+	best := make([]Move, 0, len(moves))
+	bestDistance := -1
+	for _, move := range moves {
+		distance := abs(2*move.Row-(size-1)) + abs(2*move.Col-(size-1))
+		if bestDistance == -1 || distance < bestDistance {
+			best = best[:0]
+			bestDistance = distance
+		}
+		if distance == bestDistance {
+			best = append(best, move)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+// perfectMove searches the game tree with minimax and alpha-beta pruning and
+// returns one of the best moves at random. Small boards are solved
+// completely; larger ones are searched to a fixed depth.
+func perfectMove(gs *GameState, rules Rules, moves []Move) Move {
+	search := newBotSearch(gs, rules)
+	depth := searchDepth(gs)
+	mover := gs.CurrentPlayer
+
+	best := make([]Move, 0, len(moves))
+	bestScore := -botWinScore - 1
+	for _, move := range moves {
+		next := gs.Clone()
+		next.advance(rules, move)
+
+		var score int
+		if next.Status == GameStatusFinished {
+			score = outcomeScore(next, mover)
+		} else {
+			// Search just below the best score so equally good moves are found
+			score = -search.negamax(next, depth-1, -botWinScore-1, -(bestScore - 1))
+		}
+
+		if score > bestScore {
+			best = best[:0]
+			bestScore = score
+		}
+		if score == bestScore {
+			best = append(best, move)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+// Bounds of transposition table scores
+const (
+	boundExact = iota
+	boundLower
+	boundUpper
+)
+
+// searchEntry is a transposition table entry
+type searchEntry struct {
+	depth int
+	score int
+	bound int
+}
+
+// botSearch holds the state of one game-tree search
+type botSearch struct {
+	rules Rules
+	table map[string]searchEntry // Positions already scored, nil when disabled
+}
+
+// newBotSearch prepares a search. Games whose whole position is the board remember
+// positions they have already scored, since the same board is reached by many move orders.
+func newBotSearch(gs *GameState, rules Rules) *botSearch {
+	search := &botSearch{rules: rules}
+	if gs.VariantState == nil {
+		search.table = make(map[string]searchEntry)
+	}
+	return search
+}
+
+// negamax scores a position for the player to move
+func (s *botSearch) negamax(gs *GameState, depth, alpha, beta int) int {
+	originalAlpha := alpha
+
+	key := ""
+	if s.table != nil {
+		key = positionKey(gs)
+		if entry, ok := s.table[key]; ok && entry.depth >= depth {
+			switch entry.bound {
+			case boundExact:
+				return entry.score
+			case boundLower:
+				alpha = max(alpha, entry.score)
+			case boundUpper:
+				beta = min(beta, entry.score)
+			}
+			if alpha >= beta {
+				return entry.score
+			}
+		}
+	}
+
+	mover := gs.CurrentPlayer
+	best := -botWinScore - 1
+
+	for _, move := range candidateMoves(gs, s.rules) {
+		next := gs.Clone()
+		next.advance(s.rules, move)
+
+		var score int
+		switch {
+		case next.Status == GameStatusFinished:
+			score = outcomeScore(next, mover)
+		case depth <= 1:
+			score = 0 // Unknown beyond the search horizon
+		default:
+			score = -s.negamax(next, depth-1, -beta, -alpha)
+		}
+
+		best = max(best, score)
+		alpha = max(alpha, score)
+		if alpha >= beta {
+			break
+		}
+	}
+
+	if s.table != nil {
+		bound := boundExact
+		if best <= originalAlpha {
+			bound = boundUpper
+		} else if best >= beta {
+			bound = boundLower
+		}
+		s.table[key] = searchEntry{depth: depth, score: best, bound: bound}
+	}
+
+	return best
+}
+
+// positionKey identifies a single-board position and the player to move
+func positionKey(gs *GameState) string {
+	key := make([]byte, 0, gs.BoardSize*gs.BoardSize+1)
+	for _, row := range gs.Board {
+		for _, cell := range row {
+			switch cell {
+			case SymbolX:
+				key = append(key, 'X')
+			case SymbolO:
+				key = append(key, 'O')
+			default:
+				key = append(key, '.')
+			}
+		}
+	}
+	return string(append(key, gs.CurrentPlayer[0]))
+}
+
+// outcomeScore scores a finished game for the given player, preferring quick
+// wins and slow losses. Scores depend only on the final position, so they can
+// be shared between searches from different roots.
+func outcomeScore(gs *GameState, player PlayerSymbol) int {
+	switch gs.WinnerSymbol() {
+	case SymbolEmpty:
+		return 0
+	case player:
+		return botWinScore - gs.MoveCount
+	default:
+		return -(botWinScore - gs.MoveCount)
+	}
+}
+
+// searchDepth returns how many moves ahead the perfect bot searches
+func searchDepth(gs *GameState) int {
+	if depth := gs.BoardVariant().SearchDepth; depth > 0 {
+		return depth
+	}
+	return gs.BoardSize * gs.BoardSize // The whole game
+}
+
+// candidateMoves lists the moves worth considering. On large boards only
+// cells next to existing marks are searched.
+func candidateMoves(gs *GameState, rules Rules) []Move {
+	moves := rules.LegalMoves(gs)
+	if gs.BoardSize <= 5 {
+		return moves
+	}
+
+	if gs.MoveCount == 0 {
+		center := gs.BoardSize / 2
+		return []Move{{Row: center, Col: center}}
+	}
+
+	nearby := make([]Move, 0, len(moves))
+	for _, move := range moves {
+		if hasNeighbour(gs.Board, move.Row, move.Col) {
+			nearby = append(nearby, move)
+		}
+	}
+	return nearby
+}
+
+// hasNeighbour reports whether any cell around (row, col) is occupied
+func hasNeighbour(board [][]PlayerSymbol, row, col int) bool {
+	for r := max(row-1, 0); r <= min(row+1, len(board)-1); r++ {
+		for c := max(col-1, 0); c <= min(col+1, len(board)-1); c++ {
+			if board[r][c] != SymbolEmpty {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Notification codes for direct challenges
+const (
+	NotificationCodeChallenge         = 101 // Sent to the challenged player
+	NotificationCodeChallengeAccepted = 102 // Sent to the challenger with the new match
+	NotificationCodeChallengeDeclined = 103 // Sent to the challenger
+	NotificationCodeChallengeExpired  = 104 // Sent to the challenger when nobody answered
+)
+
+// ChallengeTTL is how long a challenge waits for an answer
+const ChallengeTTL = 5 * time.Minute
+
+// friendStateMutual is the Nakama friend state for two users who are friends with each other
+const friendStateMutual = 0
+
+// Challenge is a pending invitation to play, stored under the challenged
+// player and keyed by the challenger so each pair has at most one
+type Challenge struct {
+	ChallengerID string `json:"challenger_id"`
+	RecipientID  string `json:"recipient_id"`
+	GameMode     string `json:"game_mode"`
+	Variant      string `json:"variant"`
+	CreatedAt    int64  `json:"created_at"` // Unix ms
+	ExpiresAt    int64  `json:"expires_at"` // Unix ms
+}
+
+// ChallengePlayerRequest represents a request to challenge a friend
+type ChallengePlayerRequest struct {
+	UserID   string `json:"user_id"`
+	GameMode string `json:"game_mode,omitempty"` // "casual" (default) or "ranked"
+	Variant  string `json:"variant,omitempty"`
+}
+
+// AnswerChallengeRequest identifies the challenge being accepted or declined
+type AnswerChallengeRequest struct {
+	ChallengerID string `json:"challenger_id"`
+}
+
+// AcceptChallengeResponse carries the match created for an accepted challenge
+type AcceptChallengeResponse struct {
+	MatchID  string       `json:"match_id"`
+	Symbol   PlayerSymbol `json:"symbol"`
+	GameMode string       `json:"game_mode"`
+	Variant  string       `json:"variant"`
+}
+
+// ListChallengesResponse lists the challenges waiting for the caller
+type ListChallengesResponse struct {
+	Challenges []Challenge `json:"challenges"`
+}
+
+// RpcChallengePlayer invites a friend to a game and notifies them
+func RpcChallengePlayer(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	var request ChallengePlayerRequest
+	if err := json.Unmarshal([]byte(payload), &request); err != nil {
+		logger.Error("Failed to unmarshal request: %v", err)
+		return "", runtime.NewError("invalid request payload", 3)
+	}
+
+	if request.UserID == "" {
+		return "", runtime.NewError("user_id is required", 3)
+	}
+	if request.UserID == userID {
+		return "", runtime.NewError("cannot challenge yourself", 3)
+	}
+	if request.GameMode == "" {
+		request.GameMode = GameModeCasual
+	}
+	if request.GameMode != GameModeCasual && request.GameMode != GameModeRanked {
+		return "", runtime.NewError("invalid game_mode, must be 'casual' or 'ranked'", 3)
+	}
+
+	variant, err := LookupVariant(request.Variant, 0)
+	if err != nil {
+		return "", runtime.NewError("invalid variant", 3)
+	}
+
+	friends, err := AreFriends(ctx, nk, userID, request.UserID)
+	if err != nil {
+		logger.Error("Failed to check friendship: %v", err)
+		return "", runtime.NewError("failed to send challenge", 13)
+	}
+	if !friends {
+		return "", runtime.NewError("can only challenge friends", 9)
+	}
+
+	now := time.Now()
+	challenge := Challenge{
+		ChallengerID: userID,
+		RecipientID:  request.UserID,
+		GameMode:     request.GameMode,
+		Variant:      variant.Name,
+		CreatedAt:    now.UnixMilli(),
+		ExpiresAt:    now.Add(ChallengeTTL).UnixMilli(),
+	}
+
+	// A new challenge to the same friend replaces the previous one
+	if err := saveChallenge(ctx, nk, &challenge); err != nil {
+		logger.Error("Failed to save challenge: %v", err)
+		return "", runtime.NewError("failed to send challenge", 13)
+	}
+
+	content := map[string]interface{}{
+		"challenger_id": challenge.ChallengerID,
+		"game_mode":     challenge.GameMode,
+		"variant":       challenge.Variant,
+		"expires_at":    challenge.ExpiresAt,
+	}
+	if err := nk.NotificationSend(ctx, challenge.RecipientID, "Challenge received", content, NotificationCodeChallenge, userID, true); err != nil {
+		logger.Error("Failed to notify player %s of challenge: %v", challenge.RecipientID, err)
+	}
+
+	logger.Info("Challenge sent - From: %s, To: %s, Mode: %s, Variant: %s", userID, challenge.RecipientID, challenge.GameMode, challenge.Variant)
+
+	responseJSON, err := json.Marshal(challenge)
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// RpcAcceptChallenge creates the match for a pending challenge and tells the challenger
+func RpcAcceptChallenge(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	challenge, err := claimChallenge(ctx, logger, nk, userID, payload)
+	if err != nil {
+		return "", err
+	}
+
+	playerA := MatchPlayer{UserID: challenge.ChallengerID}
+	playerB := MatchPlayer{UserID: challenge.RecipientID}
+	if profile, err := GetUserProfile(ctx, logger, nk, playerA.UserID); err == nil {
+		playerA.Rating = profile.Rating
+	}
+	if profile, err := GetUserProfile(ctx, logger, nk, playerB.UserID); err == nil {
+		playerB.Rating = profile.Rating
+	}
+
+	assignment, err := CreateGameMatch(ctx, logger, nk, playerA, playerB, MatchOptions{
+		GameMode: challenge.GameMode,
+		Variant:  challenge.Variant,
+	})
+	if err != nil {
+		logger.Error("Failed to create challenge match: %v", err)
+		return "", runtime.NewError("failed to create match", 13)
+	}
+
+	content := map[string]interface{}{
+		"match_id":     assignment.MatchID,
+		"recipient_id": challenge.RecipientID,
+		"symbol":       string(assignment.SymbolFor(challenge.ChallengerID)),
+		"game_mode":    assignment.GameMode,
+		"variant":      assignment.Variant,
+	}
+	if err := nk.NotificationSend(ctx, challenge.ChallengerID, "Challenge accepted", content, NotificationCodeChallengeAccepted, userID, true); err != nil {
+		logger.Error("Failed to notify player %s of accepted challenge: %v", challenge.ChallengerID, err)
+	}
+
+	logger.Info("Challenge accepted - From: %s, To: %s, Match: %s", challenge.ChallengerID, userID, assignment.MatchID)
+
+	responseJSON, err := json.Marshal(AcceptChallengeResponse{
+		MatchID:  assignment.MatchID,
+		Symbol:   assignment.SymbolFor(userID),
+		GameMode: assignment.GameMode,
+		Variant:  assignment.Variant,
+	})
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// RpcDeclineChallenge removes a pending challenge and tells the challenger
+func RpcDeclineChallenge(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	challenge, err := claimChallenge(ctx, logger, nk, userID, payload)
+	if err != nil {
+		return "", err
+	}
+
+	content := map[string]interface{}{
+		"recipient_id": challenge.RecipientID,
+	}
+	if err := nk.NotificationSend(ctx, challenge.ChallengerID, "Challenge declined", content, NotificationCodeChallengeDeclined, userID, true); err != nil {
+		logger.Error("Failed to notify player %s of declined challenge: %v", challenge.ChallengerID, err)
+	}
+
+	logger.Info("Challenge declined - From: %s, To: %s", challenge.ChallengerID, userID)
+
+	return `{"success": true, "message": "challenge declined"}`, nil
+}
+
+// RpcListChallenges lists the unexpired challenges waiting for the caller
+func RpcListChallenges(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	response := ListChallengesResponse{
+		Challenges: []Challenge{},
+	}
+	now := time.Now().UnixMilli()
+
+	cursor := ""
+	for {
+		objects, nextCursor, err := nk.StorageList(ctx, "", userID, "challenges", 100, cursor)
+		if err != nil {
+			logger.Error("Failed to list challenges: %v", err)
+			return "", runtime.NewError("failed to list challenges", 13)
+		}
+
+		for _, obj := range objects {
+			var challenge Challenge
+			if err := json.Unmarshal([]byte(obj.Value), &challenge); err != nil {
+				continue
+			}
+			if now < challenge.ExpiresAt {
+				response.Challenges = append(response.Challenges, challenge)
+			}
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// claimChallenge removes the caller's pending challenge from the requested
+// challenger, so it can be answered exactly once
+func claimChallenge(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, userID, payload string) (*Challenge, error) {
+	var request AnswerChallengeRequest
+	if err := json.Unmarshal([]byte(payload), &request); err != nil {
+		logger.Error("Failed to unmarshal request: %v", err)
+		return nil, runtime.NewError("invalid request payload", 3)
+	}
+	if request.ChallengerID == "" {
+		return nil, runtime.NewError("challenger_id is required", 3)
+	}
+
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{
+		{
+			Collection: "challenges",
+			Key:        request.ChallengerID,
+			UserID:     userID,
+		},
+	})
+	if err != nil {
+		logger.Error("Failed to read challenge: %v", err)
+		return nil, runtime.NewError("failed to read challenge", 13)
+	}
+	if len(objects) == 0 {
+		return nil, runtime.NewError("challenge not found", 5)
+	}
+
+	var challenge Challenge
+	if err := json.Unmarshal([]byte(objects[0].Value), &challenge); err != nil {
+		logger.Error("Failed to unmarshal challenge: %v", err)
+		return nil, runtime.NewError("failed to read challenge", 13)
+	}
+
+	// Losing the version check means the challenge was answered or replaced meanwhile
+	err = nk.StorageDelete(ctx, []*runtime.StorageDelete{
+		{
+			Collection: "challenges",
+			Key:        request.ChallengerID,
+			UserID:     userID,
+			Version:    objects[0].Version,
+		},
+	})
+	if err != nil {
+		return nil, runtime.NewError("challenge not found", 5)
+	}
+
+	if time.Now().UnixMilli() >= challenge.ExpiresAt {
+		return nil, runtime.NewError("challenge expired", 5)
+	}
+
+	return &challenge, nil
+}
+
+// ExpireChallenges removes challenges nobody answered in time and tells their challengers
+func ExpireChallenges(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule) (int, error) {
+	now := time.Now().UnixMilli()
+	expired := 0
+
+	cursor := ""
+	for {
+		objects, nextCursor, err := nk.StorageList(ctx, "", "", "challenges", 100, cursor)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, obj := range objects {
+			var challenge Challenge
+			if err := json.Unmarshal([]byte(obj.Value), &challenge); err != nil {
+				continue
+			}
+			if now < challenge.ExpiresAt {
+				continue
+			}
+
+			// Skip challenges answered or replaced since they were listed
+			err := nk.StorageDelete(ctx, []*runtime.StorageDelete{
+				{
+					Collection: "challenges",
+					Key:        obj.Key,
+					UserID:     obj.UserId,
+					Version:    obj.Version,
+				},
+			})
+			if err != nil {
+				continue
+			}
+			expired++
+
+			content := map[string]interface{}{
+				"recipient_id": challenge.RecipientID,
+			}
+			if err := nk.NotificationSend(ctx, challenge.ChallengerID, "Challenge expired", content, NotificationCodeChallengeExpired, "", true); err != nil {
+				logger.Error("Failed to notify player %s of expired challenge: %v", challenge.ChallengerID, err)
+			}
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	return expired, nil
+}
+
+// AreFriends reports whether two users are mutual friends
+func AreFriends(ctx context.Context, nk runtime.NakamaModule, userID, otherID string) (bool, error) {
+	state := friendStateMutual
+	cursor := ""
+	for {
+		friends, nextCursor, err := nk.FriendsList(ctx, userID, 100, &state, cursor)
+		if err != nil {
+			return false, err
+		}
+		for _, friend := range friends {
+			if friend.GetUser().GetId() == otherID {
+				return true, nil
+			}
+		}
+		if nextCursor == "" {
+			return false, nil
+		}
+		cursor = nextCursor
+	}
+}
+
+// saveChallenge stores a challenge under the challenged player
+func saveChallenge(ctx context.Context, nk runtime.NakamaModule, challenge *Challenge) error {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return err
+	}
+
+	_, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{
+		{
+			Collection:      "challenges",
+			Key:             challenge.ChallengerID,
+			UserID:          challenge.RecipientID,
+			Value:           string(data),
+			PermissionRead:  1, // Owner read
+			PermissionWrite: 0, // No client write
+		},
+	})
+	return err
+}
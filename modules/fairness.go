@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	mathrand "math/rand"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// How the first mover (X) of a game was chosen, recorded in GameState.FirstMove
+const (
+	FirstMoveBalance   = "balance"   // The player who has played X less often recently moves first
+	FirstMoveCoinFlip  = "coin_flip" // Recent histories were level, so a random coin flip decided
+	FirstMoveAlternate = "alternate" // Rematch or next game of a set: the previous game's O moves first
+	FirstMoveChosen    = "chosen"    // The player picked their symbol against the bot
+)
+
+// FirstMoveWindow is how many of a player's most recent games are weighed when picking the first mover
+const FirstMoveWindow = 20
+
+// AssignSymbols decides which of two players plays X (and moves first). Whoever
+// has played X more often over their recent games gets O; level histories are
+// settled by a cryptographically random coin flip. It returns the X player,
+// the O player and the policy that decided.
+func AssignSymbols(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, playerA, playerB MatchPlayer) (MatchPlayer, MatchPlayer, string) {
+	balanceA, errA := SymbolBalance(ctx, nk, playerA.UserID)
+	balanceB, errB := SymbolBalance(ctx, nk, playerB.UserID)
+	if errA != nil || errB != nil {
+		logger.Warn("Failed to read recent symbols, flipping a coin - %s: %v, %s: %v", playerA.UserID, errA, playerB.UserID, errB)
+		balanceA, balanceB = 0, 0
+	}
+
+	switch {
+	case balanceA < balanceB:
+		return playerA, playerB, FirstMoveBalance
+	case balanceB < balanceA:
+		return playerB, playerA, FirstMoveBalance
+	case CoinFlip():
+		return playerA, playerB, FirstMoveCoinFlip
+	default:
+		return playerB, playerA, FirstMoveCoinFlip
+	}
+}
+
+// SymbolBalance returns how many more of a player's recent games they played
+// as X than as O. The bot has no history and is always level.
+func SymbolBalance(ctx context.Context, nk runtime.NakamaModule, userID string) (int, error) {
+	if IsBotUser(userID) || userID == "" {
+		return 0, nil
+	}
+
+	objects, _, err := nk.StorageList(ctx, "", userID, "match_history", FirstMoveWindow, "")
+	if err != nil {
+		return 0, err
+	}
+
+	balance := 0
+	for _, obj := range objects {
+		var entry MatchHistoryEntry
+		if err := json.Unmarshal([]byte(obj.Value), &entry); err != nil {
+			continue
+		}
+		switch entry.Symbol {
+		case SymbolX:
+			balance++
+		case SymbolO:
+			balance--
+		}
+	}
+	return balance, nil
+}
+
+// CoinFlip returns true or false with equal, unpredictable probability
+func CoinFlip() bool {
+	var b [1]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Only reachable if the OS entropy source is unavailable
+		return mathrand.Intn(2) == 0
+	}
+	return b[0]&1 == 0
+}
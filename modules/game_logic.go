@@ -5,15 +5,19 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/heroiclabs/nakama-common/runtime"
 )
 
 // MakeMoveRequest represents a move request
 type MakeMoveRequest struct {
-	MatchID string `json:"match_id"`
-	Row     int    `json:"row"`
-	Col     int    `json:"col"`
+	MatchID  string `json:"match_id"`
+	SubBoard int    `json:"sub_board"` // Ultimate only: sub-board index 0-8
+	Row      int    `json:"row"`
+	Col      int    `json:"col"`
+	Symbol   string `json:"symbol,omitempty"` // Wild only: "X" or "O"
 }
 
 // MakeMoveResponse represents the response after a move
@@ -33,6 +37,11 @@ type ResignGameRequest struct {
 	MatchID string `json:"match_id"`
 }
 
+// Move returns the move described by the request
+func (r *MakeMoveRequest) Move() Move {
+	return Move{SubBoard: r.SubBoard, Row: r.Row, Col: r.Col, Symbol: PlayerSymbol(r.Symbol)}
+}
+
 // RpcMakeMove handles a player making a move
 func RpcMakeMove(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
 	// Get user ID from context
@@ -51,6 +60,32 @@ func RpcMakeMove(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runt
 		return "", runtime.NewError("match_id is required", 3)
 	}
 
+	// Games hosted by a real-time match are played through the match itself
+	signal, live, err := SignalLiveMatch(ctx, nk, request.MatchID, &MatchSignalRequest{
+		Action: SignalActionMove,
+		UserID: userID,
+		Move:   request.Move(),
+	})
+	if err != nil {
+		logger.Error("Failed to signal match: %v", err)
+		return "", runtime.NewError("failed to apply move", 13)
+	}
+	if live {
+		response := MakeMoveResponse{
+			Success: signal.Success,
+			Message: signal.Message,
+		}
+		if signal.GameState != nil {
+			response.GameState = *signal.GameState
+		}
+		if signal.Success {
+			response.Message = "move successful"
+		}
+
+		responseJSON, _ := json.Marshal(response)
+		return string(responseJSON), nil
+	}
+
 	// Load game state from storage
 	gameState, err := LoadGameState(ctx, nk, request.MatchID)
 	if err != nil {
@@ -59,7 +94,7 @@ func RpcMakeMove(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runt
 	}
 
 	// Apply the move
-	if err := gameState.ApplyMove(request.Row, request.Col, userID); err != nil {
+	if err := gameState.PlayMove(request.Move(), userID, time.Now(), 0); err != nil {
 		logger.Warn("Invalid move: %v", err)
 		response := MakeMoveResponse{
 			Success:   false,
@@ -112,7 +147,13 @@ func RpcGetGameState(ctx context.Context, logger runtime.Logger, db *sql.DB, nk
 		return "", runtime.NewError("match_id is required", 3)
 	}
 
-	gameState, err := LoadGameState(ctx, nk, request.MatchID)
+	gameID, err := ResolveGameID(ctx, nk, request.MatchID)
+	if err != nil {
+		logger.Error("Failed to resolve current game: %v", err)
+		return "", runtime.NewError("failed to load game state", 13)
+	}
+
+	gameState, err := LoadGameState(ctx, nk, gameID)
 	if err != nil {
 		logger.Error("Failed to load game state: %v", err)
 		return "", runtime.NewError("game not found", 5)
@@ -144,6 +185,28 @@ func RpcResignGame(ctx context.Context, logger runtime.Logger, db *sql.DB, nk ru
 		return "", runtime.NewError("match_id is required", 3)
 	}
 
+	// Games hosted by a real-time match are resigned through the match itself
+	signal, live, err := SignalLiveMatch(ctx, nk, request.MatchID, &MatchSignalRequest{
+		Action: SignalActionResign,
+		UserID: userID,
+	})
+	if err != nil {
+		logger.Error("Failed to signal match: %v", err)
+		return "", runtime.NewError("failed to resign game", 13)
+	}
+	if live {
+		if !signal.Success {
+			return "", runtime.NewError(signal.Message, 9) // FAILED_PRECONDITION
+		}
+
+		responseJSON, err := json.Marshal(signal.GameState)
+		if err != nil {
+			logger.Error("Failed to marshal response: %v", err)
+			return "", runtime.NewError("failed to create response", 13)
+		}
+		return string(responseJSON), nil
+	}
+
 	gameState, err := LoadGameState(ctx, nk, request.MatchID)
 	if err != nil {
 		logger.Error("Failed to load game state: %v", err)
@@ -151,14 +214,7 @@ func RpcResignGame(ctx context.Context, logger runtime.Logger, db *sql.DB, nk ru
 	}
 
 	// Mark game as finished with opponent as winner
-	gameState.Status = GameStatusFinished
-	if userID == gameState.PlayerX {
-		gameState.Result = GameResultOWins
-		gameState.Winner = gameState.PlayerO
-	} else {
-		gameState.Result = GameResultXWins
-		gameState.Winner = gameState.PlayerX
-	}
+	gameState.Forfeit(userID)
 
 	// Save updated state
 	if err := SaveGameState(ctx, nk, gameState); err != nil {
@@ -182,6 +238,33 @@ func RpcResignGame(ctx context.Context, logger runtime.Logger, db *sql.DB, nk ru
 	return string(responseJSON), nil
 }
 
+// SignalLiveMatch forwards an action to the authoritative match hosting a game.
+// It reports false when the game is not hosted by a running match.
+func SignalLiveMatch(ctx context.Context, nk runtime.NakamaModule, matchID string, request *MatchSignalRequest) (*MatchSignalResponse, bool, error) {
+	matchID = MatchIDOf(matchID)
+	match, err := nk.MatchGet(ctx, matchID)
+	if err != nil || match == nil || !match.Authoritative {
+		return nil, false, nil
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, true, err
+	}
+
+	result, err := nk.MatchSignal(ctx, matchID, string(data))
+	if err != nil {
+		return nil, true, err
+	}
+
+	var response MatchSignalResponse
+	if err := json.Unmarshal([]byte(result), &response); err != nil {
+		return nil, true, err
+	}
+
+	return &response, true, nil
+}
+
 // LoadGameState loads a game state from storage
 func LoadGameState(ctx context.Context, nk runtime.NakamaModule, matchID string) (*GameState, error) {
 	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{
@@ -203,8 +286,80 @@ func LoadGameState(ctx context.Context, nk runtime.NakamaModule, matchID string)
 	return GameStateFromJSON(objects[0].Value)
 }
 
+// CurrentGame points a match at the game it is playing once it has moved past its first
+type CurrentGame struct {
+	GameID string `json:"game_id"`
+	Game   int    `json:"game"`
+}
+
+// SaveCurrentGame records the game a match is now playing, so its match ID resolves to it
+func SaveCurrentGame(ctx context.Context, nk runtime.NakamaModule, gameState *GameState) error {
+	data, err := json.Marshal(CurrentGame{GameID: gameState.GameID(), Game: gameState.Game})
+	if err != nil {
+		return err
+	}
+
+	_, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{
+		{
+			Collection:      "match_games",
+			Key:             gameState.MatchID,
+			UserID:          "",
+			Value:           string(data),
+			PermissionRead:  2, // Public read
+			PermissionWrite: 0, // No client write
+		},
+	})
+	return err
+}
+
+// ResolveGameID maps a plain match ID to the game the match is currently on, or
+// last played. Explicit "<match_id>#<n>" game IDs are returned as given, with
+// "#1" naming the first game.
+func ResolveGameID(ctx context.Context, nk runtime.NakamaModule, id string) (string, error) {
+	if matchID, game, ok := strings.Cut(id, "#"); ok {
+		if game == "1" {
+			return matchID, nil
+		}
+		return id, nil
+	}
+
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{
+		{
+			Collection: "match_games",
+			Key:        id,
+			UserID:     "",
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(objects) == 0 {
+		// The match never went past its first game
+		return id, nil
+	}
+
+	var current CurrentGame
+	if err := json.Unmarshal([]byte(objects[0].Value), &current); err != nil {
+		return "", err
+	}
+	return current.GameID, nil
+}
+
 // SaveGameState saves a game state to storage
 func SaveGameState(ctx context.Context, nk runtime.NakamaModule, gameState *GameState) error {
+	return SaveGameStateVersion(ctx, nk, gameState, "")
+}
+
+// ActiveGame indexes a game that is still being played, so sweeps need not read finished games
+type ActiveGame struct {
+	MatchID string `json:"match_id"`
+	Game    int    `json:"game,omitempty"`
+}
+
+// SaveGameStateVersion saves a game state only if the stored copy is still at the
+// given version. An empty version writes unconditionally. The active_games index
+// is updated in the same write: active games are (re)indexed, others removed.
+func SaveGameStateVersion(ctx context.Context, nk runtime.NakamaModule, gameState *GameState, version string) error {
 	data, err := gameState.ToJSON()
 	if err != nil {
 		return err
@@ -213,23 +368,76 @@ func SaveGameState(ctx context.Context, nk runtime.NakamaModule, gameState *Game
 	writes := []*runtime.StorageWrite{
 		{
 			Collection:      "games",
-			Key:             gameState.MatchID,
+			Key:             gameState.GameID(),
 			UserID:          "",
 			Value:           data,
+			Version:         version,
 			PermissionRead:  1, // Public read
 			PermissionWrite: 0, // No client write
 		},
 	}
+	var deletes []*runtime.StorageDelete
 
-	if _, err := nk.StorageWrite(ctx, writes); err != nil {
+	if gameState.Status == GameStatusActive {
+		index, err := json.Marshal(ActiveGame{MatchID: gameState.MatchID, Game: gameState.Game})
+		if err != nil {
+			return err
+		}
+		writes = append(writes, &runtime.StorageWrite{
+			Collection:      "active_games",
+			Key:             gameState.GameID(),
+			UserID:          "",
+			Value:           string(index),
+			PermissionRead:  0, // No client read
+			PermissionWrite: 0, // No client write
+		})
+	} else {
+		deletes = append(deletes, &runtime.StorageDelete{
+			Collection: "active_games",
+			Key:        gameState.GameID(),
+			UserID:     "",
+		})
+	}
+
+	if _, _, err := nk.MultiUpdate(ctx, nil, writes, deletes, nil, false); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// UpdatePlayerStats updates player statistics after a game
+// UpdatePlayerStats updates player statistics after a game and indexes it in
+// both players' match history and their head-to-head record
 func UpdatePlayerStats(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, gameState *GameState) error {
+	if gameState.EndedAt == 0 {
+		gameState.EndedAt = time.Now().UnixMilli()
+	}
+
+	if err := updateRecords(ctx, logger, nk, gameState); err != nil {
+		return err
+	}
+
+	recordGameHistory(ctx, logger, nk, gameState)
+	return nil
+}
+
+// recordGameHistory indexes a finished game in both players' match history and head-to-head record
+func recordGameHistory(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, gameState *GameState) {
+	if err := RecordMatchHistory(ctx, nk, gameState); err != nil {
+		logger.Error("Failed to record match history: %v", err)
+	}
+	if err := RecordHeadToHead(ctx, nk, gameState); err != nil {
+		logger.Error("Failed to record head-to-head: %v", err)
+	}
+}
+
+// updateRecords applies a finished game to both players' records and ratings
+func updateRecords(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, gameState *GameState) error {
+	// Bot games never move ratings; only the human side is recorded
+	if gameState.HasBot() {
+		return updateBotGameStats(ctx, logger, nk, gameState)
+	}
+
 	// Update Player X stats
 	profileX, err := GetUserProfile(ctx, logger, nk, gameState.PlayerX)
 	if err != nil {
@@ -242,23 +450,46 @@ func UpdatePlayerStats(ctx context.Context, logger runtime.Logger, nk runtime.Na
 		return err
 	}
 
+	// Casual games are recorded separately and never move ratings
+	if gameState.GameMode != GameModeRanked {
+		switch {
+		case gameState.WinnerSymbol() == SymbolX:
+			profileX.Casual.Wins++
+			profileO.Casual.Losses++
+		case gameState.WinnerSymbol() == SymbolO:
+			profileO.Casual.Wins++
+			profileX.Casual.Losses++
+		case gameState.Result == GameResultDraw:
+			profileX.Casual.Draws++
+			profileO.Casual.Draws++
+		}
+
+		gameState.RatingChangeX = 0
+		gameState.RatingChangeO = 0
+
+		if err := UpdateUserProfile(ctx, logger, nk, gameState.PlayerX, profileX); err != nil {
+			return err
+		}
+		return UpdateUserProfile(ctx, logger, nk, gameState.PlayerO, profileO)
+	}
+
 	// Store old ratings to calculate change
 	oldRatingX := profileX.Rating
 	oldRatingO := profileO.Rating
 
-	// Determine outcome and update stats
-	switch gameState.Result {
-	case GameResultXWins:
+	// Determine outcome and update stats (timeouts count as a win for the opponent)
+	switch {
+	case gameState.WinnerSymbol() == SymbolX:
 		profileX.Wins++
 		profileO.Losses++
 		// Update ratings
 		UpdateRatings(&profileX, &profileO, 1.0) // X wins
-	case GameResultOWins:
+	case gameState.WinnerSymbol() == SymbolO:
 		profileO.Wins++
 		profileX.Losses++
 		// Update ratings
 		UpdateRatings(&profileX, &profileO, 0.0) // O wins
-	case GameResultDraw:
+	case gameState.Result == GameResultDraw:
 		profileX.Draws++
 		profileO.Draws++
 		// Update ratings
@@ -281,7 +512,7 @@ func UpdatePlayerStats(ctx context.Context, logger runtime.Logger, nk runtime.Na
 		return err
 	}
 
-	// Update leaderboard for all games
+	// Update leaderboard for ranked games
 	if err := SubmitLeaderboardScore(ctx, logger, nk, gameState.PlayerX, int64(profileX.Rating)); err != nil {
 		logger.Error("Failed to update leaderboard for player X: %v", err)
 	}
@@ -291,3 +522,36 @@ func UpdatePlayerStats(ctx context.Context, logger runtime.Logger, nk runtime.Na
 
 	return nil
 }
+
+// updateBotGameStats records a game against the bot for the human player: queue
+// backfills in their vs_bot record, play_vs_bot games in their casual record
+func updateBotGameStats(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, gameState *GameState) error {
+	gameState.RatingChangeX = 0
+	gameState.RatingChangeO = 0
+
+	userID, symbol := gameState.PlayerX, SymbolX
+	if IsBotUser(userID) {
+		userID, symbol = gameState.PlayerO, SymbolO
+	}
+
+	profile, err := GetUserProfile(ctx, logger, nk, userID)
+	if err != nil {
+		return err
+	}
+
+	record := &profile.Casual
+	if gameState.BotBackfill {
+		record = &profile.VsBot
+	}
+
+	switch {
+	case gameState.WinnerSymbol() == symbol:
+		record.Wins++
+	case gameState.Result == GameResultDraw:
+		record.Draws++
+	case gameState.WinnerSymbol() != SymbolEmpty:
+		record.Losses++
+	}
+
+	return UpdateUserProfile(ctx, logger, nk, userID, profile)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // GameStatus represents the current status of the game
@@ -18,10 +20,18 @@ const (
 type GameResult string
 
 const (
-	GameResultXWins GameResult = "x_wins"
-	GameResultOWins GameResult = "o_wins"
-	GameResultDraw  GameResult = "draw"
-	GameResultNone  GameResult = "none"
+	GameResultXWins   GameResult = "x_wins"
+	GameResultOWins   GameResult = "o_wins"
+	GameResultDraw    GameResult = "draw"
+	GameResultTimeout GameResult = "timeout" // Player to move ran out of time, Winner holds the opponent
+	GameResultVoid    GameResult = "void"    // Abandoned before any move, no stats recorded
+	GameResultNone    GameResult = "none"
+)
+
+// Default time controls, in seconds. A value of 0 disables the clock.
+const (
+	DefaultMoveTimeLimit = 30
+	DefaultGameTimeLimit = 0
 )
 
 // PlayerSymbol represents X or O
@@ -33,33 +43,124 @@ const (
 	SymbolEmpty PlayerSymbol = ""
 )
 
+// BoardVariant describes a variant: its board, how many in a row win and the rules it is played by
+type BoardVariant struct {
+	Name      string `json:"name"`
+	Size      int    `json:"size"`
+	WinLength int    `json:"win_length"`
+
+	Rules       Rules `json:"-"`
+	SearchDepth int   `json:"-"` // Moves the perfect bot looks ahead, 0 = solve the whole game
+}
+
+// VariantClassic is the standard 3x3 game
+const VariantClassic = "classic"
+
+// BoardVariants is the registry of supported variants by name
+var BoardVariants = map[string]BoardVariant{
+	VariantClassic:  {Name: VariantClassic, Size: 3, WinLength: 3, Rules: LineRules{}},
+	"4x4":           {Name: "4x4", Size: 4, WinLength: 4, Rules: LineRules{}, SearchDepth: 3},
+	"5x5":           {Name: "5x5", Size: 5, WinLength: 4, Rules: LineRules{}, SearchDepth: 3},
+	"gomoku":        {Name: "gomoku", Size: 15, WinLength: 5, Rules: LineRules{}, SearchDepth: 2},
+	VariantUltimate: {Name: VariantUltimate, Size: 3, WinLength: 3, Rules: UltimateRules{}, SearchDepth: 4},
+	VariantMisere:   {Name: VariantMisere, Size: 3, WinLength: 3, Rules: MisereRules{}},
+	VariantWild:     {Name: VariantWild, Size: 3, WinLength: 3, Rules: WildRules{}},
+}
+
+// LookupVariant returns the named board variant, with an optional win length
+// override (0 keeps the variant's default). An empty name selects the classic game.
+func LookupVariant(name string, winLength int) (BoardVariant, error) {
+	if name == "" {
+		name = VariantClassic
+	}
+
+	variant, ok := BoardVariants[name]
+	if !ok {
+		return BoardVariant{}, fmt.Errorf("unknown variant: %s", name)
+	}
+
+	if winLength != 0 {
+		if winLength < 3 || winLength > variant.Size {
+			return BoardVariant{}, fmt.Errorf("win length must be between 3 and %d", variant.Size)
+		}
+		variant.WinLength = winLength
+	}
+
+	return variant, nil
+}
+
 // GameState represents the complete state of a Tic-Tac-Toe game
 type GameState struct {
-	MatchID       string             `json:"match_id"`
-	Board         [3][3]PlayerSymbol `json:"board"`
-	CurrentPlayer PlayerSymbol       `json:"current_player"`
-	PlayerX       string             `json:"player_x"`
-	PlayerO       string             `json:"player_o"`
-	Status        GameStatus         `json:"status"`
-	Result        GameResult         `json:"result"`
-	Winner        string             `json:"winner"`
-	MoveCount     int                `json:"move_count"`
-	GameMode      string             `json:"game_mode"`       // "casual" or "ranked"
-	RatingChangeX int                `json:"rating_change_x"` // ELO change for Player X
-	RatingChangeO int                `json:"rating_change_o"` // ELO change for Player O
+	MatchID       string           `json:"match_id"`
+	Game          int              `json:"game,omitempty"`          // Number of the game within its match, counting rematches
+	SeriesLength  int              `json:"series_length,omitempty"` // Set when the game is part of a best-of-N set
+	FirstMove     string           `json:"first_move,omitempty"`    // How X was chosen: balance, coin_flip, alternate or chosen
+	Board         [][]PlayerSymbol `json:"board"`
+	BoardSize     int              `json:"board_size"`
+	WinLength     int              `json:"win_length"` // Marks in a row needed to win
+	Variant       string           `json:"variant"`
+	CurrentPlayer PlayerSymbol     `json:"current_player"`
+	PlayerX       string           `json:"player_x"`
+	PlayerO       string           `json:"player_o"`
+	Status        GameStatus       `json:"status"`
+	Result        GameResult       `json:"result"`
+	Winner        string           `json:"winner"`
+	MoveCount     int              `json:"move_count"`
+	GameMode      string           `json:"game_mode"`       // "casual" or "ranked"
+	RatingChangeX int              `json:"rating_change_x"` // ELO change for Player X
+	RatingChangeO int              `json:"rating_change_o"` // ELO change for Player O
+	MoveTimeLimit int              `json:"move_time_limit"` // Seconds allowed per move, 0 = unlimited
+	GameTimeLimit int              `json:"game_time_limit"` // Seconds per player for the whole game, 0 = unlimited
+	TimeLeftX     int64            `json:"time_left_x"`     // Remaining game clock for Player X (ms)
+	TimeLeftO     int64            `json:"time_left_o"`     // Remaining game clock for Player O (ms)
+	TurnStartedAt int64            `json:"turn_started_at"` // Unix ms when the current turn began, 0 = clock stopped
+	TurnTimeLeft  int64            `json:"turn_time_left"`  // Remaining time for the player to move (ms), -1 = untimed
+	VariantState  VariantState     `json:"-"`
+	BotDifficulty string           `json:"bot_difficulty,omitempty"` // Set when one seat is held by the server-side bot
+	BotRating     int              `json:"bot_rating,omitempty"`     // Strength the "matched" bot plays at
+	BotBackfill   bool             `json:"bot_backfill,omitempty"`   // The bot stood in for a human opponent from the queue
+	Moves         []MoveRecord     `json:"moves"`                    // Every move played, in order
+	StartedAt     int64            `json:"started_at"`               // Unix ms when the game was created
+	EndedAt       int64            `json:"ended_at"`                 // Unix ms when the result was recorded, 0 while playing
 }
 
 // Move represents a player's move
 type Move struct {
-	Row int `json:"row"`
-	Col int `json:"col"`
+	SubBoard int          `json:"sub_board"` // Ultimate only: sub-board index 0-8
+	Row      int          `json:"row"`
+	Col      int          `json:"col"`
+	Symbol   PlayerSymbol `json:"symbol,omitempty"` // Wild only: symbol to place
+}
+
+// MoveRecord is a move in the game history
+type MoveRecord struct {
+	Player    string       `json:"player"`
+	Symbol    PlayerSymbol `json:"symbol"` // Mark placed on the board
+	SubBoard  int          `json:"sub_board,omitempty"`
+	Row       int          `json:"row"`
+	Col       int          `json:"col"`
+	Timestamp int64        `json:"timestamp"` // Server time of the move (unix ms)
+	Tick      int64        `json:"tick"`      // Match tick of the move, 0 outside a real-time match
+}
+
+// Move returns the move as it was submitted
+func (r MoveRecord) Move() Move {
+	return Move{SubBoard: r.SubBoard, Row: r.Row, Col: r.Col, Symbol: r.Symbol}
+}
+
+// Time returns when the move was played
+func (r MoveRecord) Time() time.Time {
+	return time.UnixMilli(r.Timestamp)
 }
 
-// NewGameState creates a new game state
-func NewGameState(matchID, playerX, playerO, gameMode string) *GameState {
-	return &GameState{
+// NewGameState creates a new game state on an empty board of the given variant
+func NewGameState(matchID, playerX, playerO, gameMode string, variant BoardVariant) *GameState {
+	gs := &GameState{
 		MatchID:       matchID,
-		Board:         [3][3]PlayerSymbol{},
+		Game:          1,
+		BoardSize:     variant.Size,
+		WinLength:     variant.WinLength,
+		Variant:       variant.Name,
 		CurrentPlayer: SymbolX, // X always starts
 		PlayerX:       playerX,
 		PlayerO:       playerO,
@@ -70,11 +171,222 @@ func NewGameState(matchID, playerX, playerO, gameMode string) *GameState {
 		GameMode:      gameMode,
 		RatingChangeX: 0, // Initialize to 0
 		RatingChangeO: 0, // Initialize to 0
+		TurnTimeLeft:  -1,
+		Moves:         []MoveRecord{},
+		StartedAt:     time.Now().UnixMilli(),
+	}
+	gs.Rules().NewState(gs)
+	return gs
+}
+
+// GameID returns the storage key of the game: the match ID for the first game
+// of a match and "<match_id>#<n>" for rematches
+func (gs *GameState) GameID() string {
+	if gs.Game <= 1 {
+		return gs.MatchID
+	}
+	return fmt.Sprintf("%s#%d", gs.MatchID, gs.Game)
+}
+
+// MatchIDOf returns the match hosting a game ID
+func MatchIDOf(gameID string) string {
+	matchID, _, _ := strings.Cut(gameID, "#")
+	return matchID
+}
+
+// SetTimeControl configures the per-move and total-game clocks (in seconds)
+func (gs *GameState) SetTimeControl(moveTimeLimit, gameTimeLimit int) {
+	if moveTimeLimit < 0 {
+		moveTimeLimit = 0
+	}
+	if gameTimeLimit < 0 {
+		gameTimeLimit = 0
+	}
+
+	gs.MoveTimeLimit = moveTimeLimit
+	gs.GameTimeLimit = gameTimeLimit
+	gs.TimeLeftX = int64(gameTimeLimit) * 1000
+	gs.TimeLeftO = int64(gameTimeLimit) * 1000
+}
+
+// IsTimed reports whether any clock applies to this game
+func (gs *GameState) IsTimed() bool {
+	return gs.MoveTimeLimit > 0 || gs.GameTimeLimit > 0
+}
+
+// StartTurnClock starts the clock for the player to move
+func (gs *GameState) StartTurnClock(now time.Time) {
+	if gs.Status != GameStatusActive || !gs.IsTimed() {
+		return
+	}
+	gs.TurnStartedAt = now.UnixMilli()
+	gs.UpdateTurnTimeLeft(now)
+}
+
+// EndTurnClock charges the elapsed turn time to the player who just moved
+// and restarts the clock for the next player if the game is still going
+func (gs *GameState) EndTurnClock(mover PlayerSymbol, now time.Time) {
+	if gs.TurnStartedAt == 0 {
+		return
+	}
+
+	if gs.GameTimeLimit > 0 {
+		elapsed := now.UnixMilli() - gs.TurnStartedAt
+		if mover == SymbolX {
+			gs.TimeLeftX = max(gs.TimeLeftX-elapsed, 0)
+		} else {
+			gs.TimeLeftO = max(gs.TimeLeftO-elapsed, 0)
+		}
+	}
+
+	gs.TurnStartedAt = 0
+	gs.TurnTimeLeft = -1
+	gs.StartTurnClock(now)
+}
+
+// UpdateTurnTimeLeft refreshes TurnTimeLeft for the player to move, using
+// whichever of the per-move and game clocks runs out first
+func (gs *GameState) UpdateTurnTimeLeft(now time.Time) int64 {
+	if gs.TurnStartedAt == 0 {
+		gs.TurnTimeLeft = -1
+		return gs.TurnTimeLeft
+	}
+
+	elapsed := now.UnixMilli() - gs.TurnStartedAt
+	remaining := int64(-1)
+
+	if gs.MoveTimeLimit > 0 {
+		remaining = int64(gs.MoveTimeLimit)*1000 - elapsed
+	}
+	if gs.GameTimeLimit > 0 {
+		clock := gs.TimeLeftX
+		if gs.CurrentPlayer == SymbolO {
+			clock = gs.TimeLeftO
+		}
+		if remaining < 0 || clock-elapsed < remaining {
+			remaining = clock - elapsed
+		}
+	}
+
+	gs.TurnTimeLeft = max(remaining, 0)
+	return gs.TurnTimeLeft
+}
+
+// CheckTimeout finishes the game in the opponent's favour when the player to
+// move has run out of time. It reports whether the game ended.
+func (gs *GameState) CheckTimeout(now time.Time) bool {
+	if gs.Status != GameStatusActive || gs.TurnStartedAt == 0 {
+		return false
+	}
+
+	if gs.UpdateTurnTimeLeft(now) > 0 {
+		return false
+	}
+
+	gs.Status = GameStatusFinished
+	gs.Result = GameResultTimeout
+	gs.TurnStartedAt = 0
+	if gs.CurrentPlayer == SymbolX {
+		gs.Winner = gs.PlayerO
+		gs.TimeLeftX = 0
+	} else {
+		gs.Winner = gs.PlayerX
+		gs.TimeLeftO = 0
+	}
+
+	return true
+}
+
+// Forfeit ends the game in favour of the opponent of the given player
+func (gs *GameState) Forfeit(playerID string) {
+	gs.Status = GameStatusFinished
+	gs.TurnStartedAt = 0
+	if playerID == gs.PlayerX {
+		gs.Result = GameResultOWins
+		gs.Winner = gs.PlayerO
+	} else {
+		gs.Result = GameResultXWins
+		gs.Winner = gs.PlayerX
+	}
+}
+
+// Void closes a game without a result
+func (gs *GameState) Void() {
+	gs.TurnStartedAt = 0
+	gs.TurnTimeLeft = -1
+	gs.Status = GameStatusFinished
+	gs.Result = GameResultVoid
+	gs.Winner = ""
+}
+
+// Abandon closes a game that nobody is playing any more. A game that never
+// saw a move is voided; otherwise the player who failed to move loses on time.
+func (gs *GameState) Abandon() {
+	if gs.MoveCount == 0 {
+		gs.Void()
+		return
+	}
+
+	gs.TurnStartedAt = 0
+	gs.TurnTimeLeft = -1
+
+	gs.Status = GameStatusFinished
+	gs.Result = GameResultTimeout
+	if gs.CurrentPlayer == SymbolX {
+		gs.Winner = gs.PlayerO
+	} else {
+		gs.Winner = gs.PlayerX
 	}
 }
 
-// ValidateMove checks if a move is legal
-func (gs *GameState) ValidateMove(row, col int, playerID string) error {
+// WinnerSymbol returns the symbol of the winning player, or SymbolEmpty for
+// draws and unfinished games
+func (gs *GameState) WinnerSymbol() PlayerSymbol {
+	switch gs.Result {
+	case GameResultXWins:
+		return SymbolX
+	case GameResultOWins:
+		return SymbolO
+	case GameResultTimeout:
+		if gs.Winner == gs.PlayerX {
+			return SymbolX
+		}
+		if gs.Winner == gs.PlayerO {
+			return SymbolO
+		}
+	}
+	return SymbolEmpty
+}
+
+// HasBot reports whether one of the seats is held by the server-side bot
+func (gs *GameState) HasBot() bool {
+	return IsBotUser(gs.PlayerX) || IsBotUser(gs.PlayerO)
+}
+
+// CurrentPlayerID returns the user ID of the player to move
+func (gs *GameState) CurrentPlayerID() string {
+	if gs.CurrentPlayer == SymbolO {
+		return gs.PlayerO
+	}
+	return gs.PlayerX
+}
+
+// BoardVariant returns the registry entry of the game's variant
+func (gs *GameState) BoardVariant() BoardVariant {
+	if variant, ok := BoardVariants[gs.Variant]; ok {
+		return variant
+	}
+	return BoardVariants[VariantClassic]
+}
+
+// Rules returns the rules for the game's variant
+func (gs *GameState) Rules() Rules {
+	return gs.BoardVariant().Rules
+}
+
+// PlayMove validates and applies a move using the rules of the game's variant,
+// recording it in the history at the given server time and match tick
+func (gs *GameState) PlayMove(move Move, playerID string, now time.Time, tick int64) error {
 	// Check if game is active
 	if gs.Status != GameStatusActive {
 		return fmt.Errorf("game is not active")
@@ -88,46 +400,34 @@ func (gs *GameState) ValidateMove(row, col int, playerID string) error {
 		return fmt.Errorf("not your turn")
 	}
 
-	// Check if move is within bounds
-	if row < 0 || row > 2 || col < 0 || col > 2 {
-		return fmt.Errorf("move out of bounds")
+	rules := gs.Rules()
+	if err := rules.ValidateMove(gs, move); err != nil {
+		return err
 	}
 
-	// Check if cell is empty
-	if gs.Board[row][col] != SymbolEmpty {
-		return fmt.Errorf("cell already occupied")
-	}
+	gs.advance(rules, move)
+	gs.Moves = append(gs.Moves, MoveRecord{
+		Player:   playerID,
+		Symbol:   rules.Cell(gs, move),
+		SubBoard: move.SubBoard,
+		Row:      move.Row,
+		Col:      move.Col,
 
+		Timestamp: now.UnixMilli(),
+		Tick:      tick,
+	})
 	return nil
 }
 
-// ApplyMove applies a move to the game state
-func (gs *GameState) ApplyMove(row, col int, playerID string) error {
-	if err := gs.ValidateMove(row, col, playerID); err != nil {
-		return err
-	}
-
-	// Place the symbol
-	gs.Board[row][col] = gs.CurrentPlayer
+// advance applies a validated move, then either ends the game or passes the turn
+func (gs *GameState) advance(rules Rules, move Move) {
+	rules.ApplyMove(gs, move)
 	gs.MoveCount++
 
-	// Check for win
-	if gs.CheckWin() {
-		gs.Status = GameStatusFinished
-		gs.Winner = playerID
-		if gs.CurrentPlayer == SymbolX {
-			gs.Result = GameResultXWins
-		} else {
-			gs.Result = GameResultOWins
-		}
-		return nil
-	}
-
-	// Check for draw
-	if gs.CheckDraw() {
-		gs.Status = GameStatusFinished
-		gs.Result = GameResultDraw
-		return nil
+	// Check for the end of the game
+	if result := rules.Outcome(gs); result != GameResultNone {
+		gs.finish(result)
+		return
 	}
 
 	// Switch player
@@ -136,50 +436,96 @@ func (gs *GameState) ApplyMove(row, col int, playerID string) error {
 	} else {
 		gs.CurrentPlayer = SymbolX
 	}
+}
 
-	return nil
+// Clone returns a deep copy of the game state
+func (gs *GameState) Clone() *GameState {
+	clone := *gs
+	clone.Board = cloneBoard(gs.Board)
+	clone.Moves = gs.Moves[:len(gs.Moves):len(gs.Moves)] // Appending to the clone's history copies it
+	if gs.VariantState != nil {
+		clone.VariantState = gs.VariantState.Clone()
+	}
+	return &clone
 }
 
-// CheckWin checks if the current player has won
-func (gs *GameState) CheckWin() bool {
-	symbol := gs.CurrentPlayer
+// finish ends the game with the given result and records the winner
+func (gs *GameState) finish(result GameResult) {
+	gs.Status = GameStatusFinished
+	gs.Result = result
+	switch result {
+	case GameResultXWins:
+		gs.Winner = gs.PlayerX
+	case GameResultOWins:
+		gs.Winner = gs.PlayerO
+	}
+}
 
-	// Check rows
-	for i := 0; i < 3; i++ {
-		if gs.Board[i][0] == symbol && gs.Board[i][1] == symbol && gs.Board[i][2] == symbol {
-			return true
-		}
+// newBoard creates an empty size x size board
+func newBoard(size int) [][]PlayerSymbol {
+	board := make([][]PlayerSymbol, size)
+	for i := range board {
+		board[i] = make([]PlayerSymbol, size)
 	}
+	return board
+}
 
-	// Check columns
-	for i := 0; i < 3; i++ {
-		if gs.Board[0][i] == symbol && gs.Board[1][i] == symbol && gs.Board[2][i] == symbol {
-			return true
-		}
+// cloneBoard returns a deep copy of a board
+func cloneBoard(board [][]PlayerSymbol) [][]PlayerSymbol {
+	clone := make([][]PlayerSymbol, len(board))
+	for i, row := range board {
+		clone[i] = append([]PlayerSymbol(nil), row...)
+	}
+	return clone
+}
+
+// MarshalJSON encodes the game, adding the variant's own state under the variant's name
+func (gs GameState) MarshalJSON() ([]byte, error) {
+	type plain GameState
+	data, err := json.Marshal(plain(gs))
+	if err != nil {
+		return nil, err
 	}
 
-	// Check diagonals
-	if gs.Board[0][0] == symbol && gs.Board[1][1] == symbol && gs.Board[2][2] == symbol {
-		return true
+	state, err := gs.Rules().Serialize(&gs)
+	if err != nil || state == nil {
+		return data, err
 	}
-	if gs.Board[0][2] == symbol && gs.Board[1][1] == symbol && gs.Board[2][0] == symbol {
-		return true
+
+	key, err := json.Marshal(gs.Variant)
+	if err != nil {
+		return nil, err
 	}
 
-	return false
+	// Append the state as the last field of the object
+	out := make([]byte, 0, len(data)+len(key)+len(state)+2)
+	out = append(out, data[:len(data)-1]...)
+	out = append(out, ',')
+	out = append(out, key...)
+	out = append(out, ':')
+	out = append(out, state...)
+	return append(out, '}'), nil
 }
 
-// CheckDraw checks if the game is a draw
-func (gs *GameState) CheckDraw() bool {
-	// All cells must be filled
-	for i := 0; i < 3; i++ {
-		for j := 0; j < 3; j++ {
-			if gs.Board[i][j] == SymbolEmpty {
-				return false
-			}
-		}
+// UnmarshalJSON decodes a game and hands the state stored under the variant's name back to its rules
+func (gs *GameState) UnmarshalJSON(data []byte) error {
+	type plain GameState
+	if err := json.Unmarshal(data, (*plain)(gs)); err != nil {
+		return err
 	}
-	return true
+
+	// Games stored before board variants existed are classic 3x3
+	if gs.BoardSize == 0 {
+		gs.BoardSize = len(gs.Board)
+		gs.WinLength = 3
+		gs.Variant = VariantClassic
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	return gs.Rules().Restore(gs, fields[gs.Variant])
 }
 
 // ToJSON converts game state to JSON string
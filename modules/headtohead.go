@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	defaultHeadToHeadLimit = 10
+
+	// maxHeadToHeadGames is how many recent games a pair record keeps
+	maxHeadToHeadGames = 50
+
+	// maxHeadToHeadWrites bounds retries when both players' games finish at once
+	maxHeadToHeadWrites = 3
+)
+
+// HeadToHeadRecord is the running record between two players, stored once per
+// pair under the pair's key with PlayerA the lower user ID
+type HeadToHeadRecord struct {
+	PlayerA string           `json:"player_a"`
+	PlayerB string           `json:"player_b"`
+	WinsA   int              `json:"wins_a"`
+	WinsB   int              `json:"wins_b"`
+	Draws   int              `json:"draws"`
+	RatingA int              `json:"rating_a"` // Net rating A has gained from B
+	RatingB int              `json:"rating_b"` // Net rating B has gained from A
+	Games   []HeadToHeadGame `json:"games"`    // Most recent first
+}
+
+// HeadToHeadGame is one finished game in a pair record
+type HeadToHeadGame struct {
+	MatchID       string `json:"match_id"`
+	GameMode      string `json:"game_mode"`
+	Variant       string `json:"variant"`
+	Winner        string `json:"winner,omitempty"` // Empty for a draw
+	RatingChangeA int    `json:"rating_change_a"`
+	RatingChangeB int    `json:"rating_change_b"`
+	EndedAt       int64  `json:"ended_at"` // Unix ms
+}
+
+// GetHeadToHeadRequest represents a request for the record between two players
+type GetHeadToHeadRequest struct {
+	UserID     string `json:"user_id,omitempty"` // Defaults to the caller
+	OpponentID string `json:"opponent_id"`
+	Limit      int    `json:"limit,omitempty"` // Recent games to return
+}
+
+// HeadToHeadGameResult is a recent game from the requesting player's point of view
+type HeadToHeadGameResult struct {
+	MatchID      string `json:"match_id"`
+	GameMode     string `json:"game_mode"`
+	Variant      string `json:"variant"`
+	Outcome      string `json:"outcome"` // "win", "loss" or "draw"
+	RatingChange int    `json:"rating_change"`
+	EndedAt      int64  `json:"ended_at"`
+}
+
+// GetHeadToHeadResponse is the record between two players from user_id's point of view
+type GetHeadToHeadResponse struct {
+	UserID     string                 `json:"user_id"`
+	OpponentID string                 `json:"opponent_id"`
+	Wins       int                    `json:"wins"`
+	Losses     int                    `json:"losses"`
+	Draws      int                    `json:"draws"`
+	NetRating  int                    `json:"net_rating"` // Rating user_id has gained from the opponent
+	Games      []HeadToHeadGameResult `json:"games"`
+}
+
+// RpcGetHeadToHead returns the record between two players
+func RpcGetHeadToHead(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	callerID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || callerID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	var request GetHeadToHeadRequest
+	if err := json.Unmarshal([]byte(payload), &request); err != nil {
+		logger.Error("Failed to unmarshal request: %v", err)
+		return "", runtime.NewError("invalid request payload", 3)
+	}
+
+	if request.UserID == "" {
+		request.UserID = callerID
+	}
+	if request.OpponentID == "" {
+		return "", runtime.NewError("opponent_id is required", 3)
+	}
+	if request.OpponentID == request.UserID {
+		return "", runtime.NewError("opponent_id must be a different player", 3)
+	}
+	if request.Limit <= 0 {
+		request.Limit = defaultHeadToHeadLimit
+	}
+	if request.Limit > maxHeadToHeadGames {
+		request.Limit = maxHeadToHeadGames
+	}
+
+	record, _, err := loadHeadToHead(ctx, nk, request.UserID, request.OpponentID)
+	if err != nil {
+		logger.Error("Failed to load head-to-head record: %v", err)
+		return "", runtime.NewError("failed to get head-to-head record", 13)
+	}
+
+	response := record.ViewFor(request.UserID, request.Limit)
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// ViewFor returns the record from one player's point of view with at most limit games
+func (r *HeadToHeadRecord) ViewFor(userID string, limit int) GetHeadToHeadResponse {
+	response := GetHeadToHeadResponse{
+		UserID:     userID,
+		OpponentID: r.PlayerB,
+		Wins:       r.WinsA,
+		Losses:     r.WinsB,
+		Draws:      r.Draws,
+		NetRating:  r.RatingA,
+		Games:      make([]HeadToHeadGameResult, 0, min(limit, len(r.Games))),
+	}
+	isA := userID == r.PlayerA
+	if !isA {
+		response.OpponentID = r.PlayerA
+		response.Wins, response.Losses = r.WinsB, r.WinsA
+		response.NetRating = r.RatingB
+	}
+
+	for _, game := range r.Games[:min(limit, len(r.Games))] {
+		result := HeadToHeadGameResult{
+			MatchID:      game.MatchID,
+			GameMode:     game.GameMode,
+			Variant:      game.Variant,
+			Outcome:      OutcomeDraw,
+			RatingChange: game.RatingChangeA,
+			EndedAt:      game.EndedAt,
+		}
+		if !isA {
+			result.RatingChange = game.RatingChangeB
+		}
+		switch game.Winner {
+		case "":
+		case userID:
+			result.Outcome = OutcomeWin
+		default:
+			result.Outcome = OutcomeLoss
+		}
+		response.Games = append(response.Games, result)
+	}
+
+	return response
+}
+
+// RecordHeadToHead adds a finished game between two human players to their
+// pair record. Recording the same game twice has no effect.
+func RecordHeadToHead(ctx context.Context, nk runtime.NakamaModule, gs *GameState) error {
+	if gs.Result == GameResultVoid || gs.HasBot() || gs.PlayerX == "" || gs.PlayerO == "" {
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt < maxHeadToHeadWrites; attempt++ {
+		record, version, loadErr := loadHeadToHead(ctx, nk, gs.PlayerX, gs.PlayerO)
+		if loadErr != nil {
+			return loadErr
+		}
+		if !record.add(gs) {
+			return nil
+		}
+
+		// Losing the version check means the pair finished another game at the
+		// same time; re-read and apply this game on top of it
+		if err = saveHeadToHead(ctx, nk, record, version); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// add applies a finished game to the record, reporting false if it was already recorded
+func (r *HeadToHeadRecord) add(gs *GameState) bool {
+	for _, game := range r.Games {
+		if game.MatchID == gs.GameID() {
+			return false
+		}
+	}
+
+	game := HeadToHeadGame{
+		MatchID:       gs.GameID(),
+		GameMode:      gs.GameMode,
+		Variant:       gs.Variant,
+		Winner:        gs.Winner,
+		RatingChangeA: gs.RatingChangeX,
+		RatingChangeB: gs.RatingChangeO,
+		EndedAt:       gs.EndedAt,
+	}
+	if r.PlayerA != gs.PlayerX {
+		game.RatingChangeA, game.RatingChangeB = gs.RatingChangeO, gs.RatingChangeX
+	}
+
+	switch game.Winner {
+	case "":
+		r.Draws++
+	case r.PlayerA:
+		r.WinsA++
+	default:
+		r.WinsB++
+	}
+	r.RatingA += game.RatingChangeA
+	r.RatingB += game.RatingChangeB
+
+	r.Games = append([]HeadToHeadGame{game}, r.Games...)
+	if len(r.Games) > maxHeadToHeadGames {
+		r.Games = r.Games[:maxHeadToHeadGames]
+	}
+	return true
+}
+
+// headToHeadKey returns the storage key for a pair, the same whichever order the players are given
+func headToHeadKey(userA, userB string) (string, string, string) {
+	if userB < userA {
+		userA, userB = userB, userA
+	}
+	return fmt.Sprintf("%s_%s", userA, userB), userA, userB
+}
+
+// loadHeadToHead reads a pair record and its version, returning an empty record
+// if the players have not finished a game together
+func loadHeadToHead(ctx context.Context, nk runtime.NakamaModule, userA, userB string) (*HeadToHeadRecord, string, error) {
+	key, playerA, playerB := headToHeadKey(userA, userB)
+
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{
+		{
+			Collection: "head_to_head",
+			Key:        key,
+			UserID:     "",
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	record := &HeadToHeadRecord{
+		PlayerA: playerA,
+		PlayerB: playerB,
+		Games:   []HeadToHeadGame{},
+	}
+	if len(objects) == 0 {
+		return record, "", nil
+	}
+
+	if err := json.Unmarshal([]byte(objects[0].Value), record); err != nil {
+		return nil, "", err
+	}
+	return record, objects[0].Version, nil
+}
+
+// saveHeadToHead writes a pair record if the stored copy is still at version.
+// An empty version only creates a new record.
+func saveHeadToHead(ctx context.Context, nk runtime.NakamaModule, record *HeadToHeadRecord, version string) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if version == "" {
+		version = "*" // Only write if no record exists yet
+	}
+
+	key, _, _ := headToHeadKey(record.PlayerA, record.PlayerB)
+	_, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{
+		{
+			Collection:      "head_to_head",
+			Key:             key,
+			UserID:          "",
+			Value:           string(data),
+			Version:         version,
+			PermissionRead:  1, // Public read
+			PermissionWrite: 0, // No client write
+		},
+	})
+	return err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Match outcomes from a single player's point of view
+const (
+	OutcomeWin  = "win"
+	OutcomeLoss = "loss"
+	OutcomeDraw = "draw"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+
+	// maxHistoryReads bounds the storage pages read for one filtered request;
+	// the returned cursor continues the scan
+	maxHistoryReads = 10
+
+	// historyKeyBase inverts end times so storage keys list newest first
+	historyKeyBase = 9999999999999
+)
+
+// MatchHistoryEntry is one finished game in a player's match history
+type MatchHistoryEntry struct {
+	MatchID      string       `json:"match_id"`
+	Opponent     string       `json:"opponent"`
+	Symbol       PlayerSymbol `json:"symbol"`
+	GameMode     string       `json:"game_mode"`
+	Variant      string       `json:"variant"`
+	Outcome      string       `json:"outcome"` // "win", "loss" or "draw"
+	Result       GameResult   `json:"result"`
+	RatingChange int          `json:"rating_change"`
+	MoveCount    int          `json:"move_count"`
+	StartedAt    int64        `json:"started_at"` // Unix ms
+	EndedAt      int64        `json:"ended_at"`   // Unix ms
+}
+
+// GetMatchHistoryRequest represents a request for a page of the caller's match history
+type GetMatchHistoryRequest struct {
+	GameMode string `json:"game_mode,omitempty"` // Only games of this mode
+	Outcome  string `json:"outcome,omitempty"`   // Only games with this outcome
+	Opponent string `json:"opponent,omitempty"`  // Only games against this user
+	Limit    int    `json:"limit,omitempty"`
+	Cursor   string `json:"cursor,omitempty"`
+}
+
+// GetMatchHistoryResponse is a page of match history, newest first
+type GetMatchHistoryResponse struct {
+	Matches []MatchHistoryEntry `json:"matches"`
+	Cursor  string              `json:"cursor,omitempty"` // Empty when there are no more games
+}
+
+// RpcGetMatchHistory lists the caller's finished games, newest first. History is
+// owner-read, so players can only list their own.
+func RpcGetMatchHistory(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	callerID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || callerID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	var request GetMatchHistoryRequest
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &request); err != nil {
+			logger.Error("Failed to unmarshal request: %v", err)
+			return "", runtime.NewError("invalid request payload", 3)
+		}
+	}
+
+	if request.GameMode != "" && request.GameMode != GameModeCasual && request.GameMode != GameModeRanked {
+		return "", runtime.NewError("invalid game_mode, must be 'casual' or 'ranked'", 3)
+	}
+	switch request.Outcome {
+	case "", OutcomeWin, OutcomeLoss, OutcomeDraw:
+	default:
+		return "", runtime.NewError("invalid outcome, must be 'win', 'loss' or 'draw'", 3)
+	}
+
+	if request.Limit <= 0 {
+		request.Limit = defaultHistoryLimit
+	}
+	if request.Limit > maxHistoryLimit {
+		request.Limit = maxHistoryLimit
+	}
+
+	response := GetMatchHistoryResponse{
+		Matches: make([]MatchHistoryEntry, 0, request.Limit),
+	}
+
+	// Read only as many entries as are still needed, so a filtered page never
+	// skips past entries it has no room for
+	cursor := request.Cursor
+	for reads := 0; reads < maxHistoryReads; reads++ {
+		objects, nextCursor, err := nk.StorageList(ctx, "", callerID, "match_history", request.Limit-len(response.Matches), cursor)
+		if err != nil {
+			logger.Error("Failed to list match history: %v", err)
+			return "", runtime.NewError("failed to get match history", 13)
+		}
+
+		for _, obj := range objects {
+			var entry MatchHistoryEntry
+			if err := json.Unmarshal([]byte(obj.Value), &entry); err != nil {
+				continue
+			}
+			if request.matches(&entry) {
+				response.Matches = append(response.Matches, entry)
+			}
+		}
+
+		cursor = nextCursor
+		if cursor == "" || len(response.Matches) >= request.Limit {
+			break
+		}
+	}
+	response.Cursor = cursor
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// matches reports whether a history entry passes the request's filters
+func (r *GetMatchHistoryRequest) matches(entry *MatchHistoryEntry) bool {
+	if r.GameMode != "" && entry.GameMode != r.GameMode {
+		return false
+	}
+	if r.Outcome != "" && entry.Outcome != r.Outcome {
+		return false
+	}
+	if r.Opponent != "" && entry.Opponent != r.Opponent {
+		return false
+	}
+	return true
+}
+
+// RecordMatchHistory indexes a finished game under each human player. Entries
+// are keyed by end time and match, so recording a game twice overwrites it.
+func RecordMatchHistory(ctx context.Context, nk runtime.NakamaModule, gs *GameState) error {
+	if gs.Result == GameResultVoid {
+		return nil
+	}
+
+	key := fmt.Sprintf("%013d_%s", historyKeyBase-gs.EndedAt, gs.GameID())
+	writes := make([]*runtime.StorageWrite, 0, 2)
+
+	for _, symbol := range []PlayerSymbol{SymbolX, SymbolO} {
+		entry := historyEntryFor(gs, symbol)
+		userID := gs.PlayerX
+		if symbol == SymbolO {
+			userID = gs.PlayerO
+		}
+		if IsBotUser(userID) {
+			continue
+		}
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		writes = append(writes, &runtime.StorageWrite{
+			Collection:      "match_history",
+			Key:             key,
+			UserID:          userID,
+			Value:           string(data),
+			PermissionRead:  1, // Owner read
+			PermissionWrite: 0, // No client write
+		})
+	}
+
+	_, err := nk.StorageWrite(ctx, writes)
+	return err
+}
+
+// historyEntryFor describes a finished game from the point of view of one seat
+func historyEntryFor(gs *GameState, symbol PlayerSymbol) MatchHistoryEntry {
+	entry := MatchHistoryEntry{
+		MatchID:      gs.GameID(),
+		Opponent:     gs.PlayerO,
+		Symbol:       symbol,
+		GameMode:     gs.GameMode,
+		Variant:      gs.Variant,
+		Outcome:      OutcomeDraw,
+		Result:       gs.Result,
+		RatingChange: gs.RatingChangeX,
+		MoveCount:    gs.MoveCount,
+		StartedAt:    gs.StartedAt,
+		EndedAt:      gs.EndedAt,
+	}
+	if symbol == SymbolO {
+		entry.Opponent = gs.PlayerX
+		entry.RatingChange = gs.RatingChangeO
+	}
+
+	switch gs.WinnerSymbol() {
+	case symbol:
+		entry.Outcome = OutcomeWin
+	case SymbolEmpty:
+	default:
+		entry.Outcome = OutcomeLoss
+	}
+
+	return entry
+}
@@ -35,13 +35,15 @@ type GetPlayerRankRequest struct {
 
 // GetPlayerRankResponse represents player rank response
 type GetPlayerRankResponse struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Rank     int64  `json:"rank"`
-	Rating   int64  `json:"rating"`
-	Wins     int    `json:"wins"`
-	Losses   int    `json:"losses"`
-	Draws    int    `json:"draws"`
+	UserID   string     `json:"user_id"`
+	Username string     `json:"username"`
+	Rank     int64      `json:"rank"`
+	Rating   int64      `json:"rating"`
+	Wins     int        `json:"wins"`
+	Losses   int        `json:"losses"`
+	Draws    int        `json:"draws"`
+	Casual   GameRecord `json:"casual"`
+	VsBot    GameRecord `json:"vs_bot"`
 }
 
 // InitializeLeaderboard creates the global leaderboard on startup
@@ -139,6 +141,8 @@ func RpcGetPlayerRank(ctx context.Context, logger runtime.Logger, db *sql.DB, nk
 		Wins:     profile.Wins,
 		Losses:   profile.Losses,
 		Draws:    profile.Draws,
+		Casual:   profile.Casual,
+		VsBot:    profile.VsBot,
 	}
 
 	responseJSON, err := json.Marshal(response)
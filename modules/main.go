@@ -33,6 +33,26 @@ func InitModule(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runti
 	}
 	logger.Info("Registered RPC: resign_game")
 
+	if err := initializer.RegisterRpc("get_game_analysis", RpcGetGameAnalysis); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: get_game_analysis")
+
+	if err := initializer.RegisterRpc("get_replay", RpcGetReplay); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: get_replay")
+
+	if err := initializer.RegisterRpc("get_match_history", RpcGetMatchHistory); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: get_match_history")
+
+	if err := initializer.RegisterRpc("get_head_to_head", RpcGetHeadToHead); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: get_head_to_head")
+
 	// Register Matchmaking RPCs
 	if err := initializer.RegisterRpc("join_queue", RpcJoinQueue); err != nil {
 		return err
@@ -44,6 +64,54 @@ func InitModule(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runti
 	}
 	logger.Info("Registered RPC: cancel_queue")
 
+	if err := initializer.RegisterRpc("queue_status", RpcQueueStatus); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: queue_status")
+
+	if err := initializer.RegisterRpc("list_matches", RpcListMatches); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: list_matches")
+
+	// Register Bot RPCs
+	if err := initializer.RegisterRpc("play_vs_bot", RpcPlayVsBot); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: play_vs_bot")
+
+	// Register Private Match RPCs
+	if err := initializer.RegisterRpc("create_private_match", RpcCreatePrivateMatch); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: create_private_match")
+
+	if err := initializer.RegisterRpc("join_by_code", RpcJoinByCode); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: join_by_code")
+
+	// Register Challenge RPCs
+	if err := initializer.RegisterRpc("challenge_player", RpcChallengePlayer); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: challenge_player")
+
+	if err := initializer.RegisterRpc("accept_challenge", RpcAcceptChallenge); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: accept_challenge")
+
+	if err := initializer.RegisterRpc("decline_challenge", RpcDeclineChallenge); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: decline_challenge")
+
+	if err := initializer.RegisterRpc("list_challenges", RpcListChallenges); err != nil {
+		return err
+	}
+	logger.Info("Registered RPC: list_challenges")
+
 	// Register Leaderboard RPCs
 	if err := initializer.RegisterRpc("get_leaderboard", RpcGetLeaderboard); err != nil {
 		return err
@@ -64,46 +132,16 @@ func InitModule(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runti
 	logger.Info("Registered Match Handler: tictactoe")
 
 	// Register Matchmaker Matched Handler for built-in matchmaking
-	if err := initializer.RegisterMatchmakerMatched(func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, entries []runtime.MatchmakerEntry) (string, error) {
-		logger.Info("Matchmaker matched %d players", len(entries))
-
-		if len(entries) != 2 {
-			logger.Error("Invalid number of players matched: %d, expected 2", len(entries))
-			return "", nil
-		}
-
-		playerIDs := make([]string, 0, 2)
-		for _, entry := range entries {
-			playerIDs = append(playerIDs, entry.GetPresence().GetUserId())
-			logger.Info("Player matched - UserID: %s, Username: %s",
-				entry.GetPresence().GetUserId(),
-				entry.GetPresence().GetUsername())
-		}
-
-		// Prevent self-matching
-		if playerIDs[0] == playerIDs[1] {
-			logger.Info("Prevented self-match for UserID: %s", playerIDs[0])
-			return "", nil
-		}
-
-		params := map[string]interface{}{
-			"player1": playerIDs[0],
-			"player2": playerIDs[1],
-		}
-
-		matchID, err := nk.MatchCreate(ctx, "tictactoe", params)
-		if err != nil {
-			logger.Error("Failed to create match: %v", err)
-			return "", err
-		}
-
-		logger.Info("Match created - Match ID: %s, Players: %s vs %s", matchID, playerIDs[0], playerIDs[1])
-		return matchID, nil
-	}); err != nil {
+	if err := initializer.RegisterMatchmakerMatched(MatchmakerMatched); err != nil {
 		return err
 	}
 	logger.Info("Registered Matchmaker Matched Handler")
 
+	if err := initializer.RegisterBeforeRt("MatchmakerAdd", BeforeMatchmakerAdd); err != nil {
+		return err
+	}
+	logger.Info("Registered Before Hook: MatchmakerAdd")
+
 	// Initialize Leaderboard
 	if err := InitializeLeaderboard(ctx, logger, nk); err != nil {
 		logger.Error("Failed to initialize leaderboard: %v", err)
@@ -111,6 +149,11 @@ func InitModule(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runti
 	}
 	logger.Info("Leaderboard initialized")
 
+	// Start the background sweeper for stale queue entries and abandoned games
+	maintenanceConfig := LoadMaintenanceConfig(ctx, logger)
+	StartMaintenance(logger, nk, maintenanceConfig)
+	logger.Info("Maintenance sweeper started - Interval: %v", maintenanceConfig.SweepInterval)
+
 	logger.Info("TicTacToe module initialization complete")
 	return nil
 }
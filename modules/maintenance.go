@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// MaintenanceConfig controls the background sweeper. Every value can be
+// overridden in seconds through the Nakama runtime env.
+type MaintenanceConfig struct {
+	SweepInterval    time.Duration // How often the sweeper runs
+	QueueEntryTTL    time.Duration // Time since the last join_queue or queue_status call after which queue entries are expired
+	QueueResultTTL   time.Duration // Age after which queue ticket results are deleted
+	AbandonedGameTTL time.Duration // Idle time after which an active stored game is closed
+	BotBackfillWait  time.Duration // Queue wait after which opted-in players are given a bot
+}
+
+// Runtime env keys for the sweeper settings
+const (
+	EnvSweepInterval    = "maintenance_sweep_interval"
+	EnvQueueEntryTTL    = "maintenance_queue_entry_ttl"
+	EnvQueueResultTTL   = "maintenance_queue_result_ttl"
+	EnvAbandonedGameTTL = "maintenance_abandoned_game_ttl"
+	EnvBotBackfillWait  = "bot_backfill_wait"
+)
+
+// DefaultMaintenanceConfig returns the sweeper settings used when no env overrides are present
+func DefaultMaintenanceConfig() MaintenanceConfig {
+	return MaintenanceConfig{
+		SweepInterval:    30 * time.Second,
+		QueueEntryTTL:    2 * time.Minute, // Well past the ranked rating window ramp
+		QueueResultTTL:   10 * time.Minute,
+		AbandonedGameTTL: 10 * time.Minute,
+		BotBackfillWait:  20 * time.Second,
+	}
+}
+
+// LoadMaintenanceConfig reads sweeper settings from the runtime env
+func LoadMaintenanceConfig(ctx context.Context, logger runtime.Logger) MaintenanceConfig {
+	config := DefaultMaintenanceConfig()
+
+	env, ok := ctx.Value(runtime.RUNTIME_CTX_ENV).(map[string]string)
+	if !ok {
+		return config
+	}
+
+	for key, target := range map[string]*time.Duration{
+		EnvSweepInterval:    &config.SweepInterval,
+		EnvQueueEntryTTL:    &config.QueueEntryTTL,
+		EnvQueueResultTTL:   &config.QueueResultTTL,
+		EnvAbandonedGameTTL: &config.AbandonedGameTTL,
+		EnvBotBackfillWait:  &config.BotBackfillWait,
+	} {
+		value, ok := env[key]
+		if !ok {
+			continue
+		}
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			logger.Warn("Ignoring invalid %s: %q", key, value)
+			continue
+		}
+		*target = time.Duration(seconds) * time.Second
+	}
+
+	return config
+}
+
+// StartMaintenance runs the sweeper on a timer for the lifetime of the server
+func StartMaintenance(logger runtime.Logger, nk runtime.NakamaModule, config MaintenanceConfig) {
+	go func() {
+		ticker := time.NewTicker(config.SweepInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			RunMaintenanceSweep(context.Background(), logger, nk, config)
+		}
+	}()
+}
+
+// RunMaintenanceSweep backfills waiting players with bots, expires stale queue
+// entries and ticket results, unanswered challenges and unused join codes, and
+// closes abandoned stored games
+func RunMaintenanceSweep(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, config MaintenanceConfig) {
+	start := time.Now()
+
+	// Backfill before expiring so opted-in players get their bot rather than a dropped ticket
+	backfilled, err := BackfillQueuedPlayers(ctx, logger, nk, config.BotBackfillWait)
+	if err != nil {
+		logger.Error("Failed to backfill queued players: %v", err)
+	}
+	nk.MetricsCounterAdd("tictactoe_sweeper_bot_backfills", nil, int64(backfilled))
+
+	expired, err := CleanupExpiredQueueEntries(ctx, logger, nk, config.QueueEntryTTL)
+	if err != nil {
+		logger.Error("Failed to expire queue entries: %v", err)
+	}
+	nk.MetricsCounterAdd("tictactoe_sweeper_queue_expired", nil, int64(expired))
+
+	results, err := CleanupExpiredQueueResults(ctx, logger, nk, config.QueueResultTTL)
+	if err != nil {
+		logger.Error("Failed to expire queue ticket results: %v", err)
+	}
+	nk.MetricsCounterAdd("tictactoe_sweeper_queue_results_expired", nil, int64(results))
+
+	challenges, err := ExpireChallenges(ctx, logger, nk)
+	if err != nil {
+		logger.Error("Failed to expire challenges: %v", err)
+	}
+	nk.MetricsCounterAdd("tictactoe_sweeper_challenges_expired", nil, int64(challenges))
+
+	joinCodes, err := ExpireJoinCodes(ctx, logger, nk)
+	if err != nil {
+		logger.Error("Failed to expire join codes: %v", err)
+	}
+	nk.MetricsCounterAdd("tictactoe_sweeper_join_codes_expired", nil, int64(joinCodes))
+
+	adjudicated, voided, err := SweepAbandonedGames(ctx, logger, nk, config.AbandonedGameTTL)
+	if err != nil {
+		logger.Error("Failed to sweep abandoned games: %v", err)
+	}
+	nk.MetricsCounterAdd("tictactoe_sweeper_games_adjudicated", nil, int64(adjudicated))
+	nk.MetricsCounterAdd("tictactoe_sweeper_games_voided", nil, int64(voided))
+
+	nk.MetricsTimerRecord("tictactoe_sweeper_duration", nil, time.Since(start))
+
+	if backfilled > 0 || expired > 0 || results > 0 || challenges > 0 || joinCodes > 0 || adjudicated > 0 || voided > 0 {
+		logger.Info("Maintenance sweep - Bot backfills: %d, Expired queue entries: %d, Expired ticket results: %d, Expired challenges: %d, Expired join codes: %d, Adjudicated games: %d, Voided games: %d",
+			backfilled, expired, results, challenges, joinCodes, adjudicated, voided)
+	}
+}
+
+// SweepAbandonedGames closes active games that have not changed for ttl and are
+// not hosted by a running match. Only games in the active_games index are read.
+// Games with moves are adjudicated against the player to move; games without
+// any are voided.
+func SweepAbandonedGames(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, ttl time.Duration) (int, int, error) {
+	cutoff := time.Now().Add(-ttl)
+	adjudicated, voided := 0, 0
+
+	cursor := ""
+	for {
+		indexed, nextCursor, err := nk.StorageList(ctx, "", "", "active_games", 100, cursor)
+		if err != nil {
+			return adjudicated, voided, err
+		}
+
+		for _, entry := range indexed {
+			// The index is rewritten with every save of its game
+			if entry.UpdateTime == nil || entry.UpdateTime.AsTime().After(cutoff) {
+				continue
+			}
+
+			objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{
+				{
+					Collection: "games",
+					Key:        entry.Key,
+					UserID:     "",
+				},
+			})
+			if err != nil {
+				return adjudicated, voided, err
+			}
+
+			var gameState *GameState
+			if len(objects) > 0 {
+				gameState, err = GameStateFromJSON(objects[0].Value)
+				if err != nil {
+					continue
+				}
+			}
+			if gameState == nil || gameState.Status != GameStatusActive {
+				dropActiveGame(ctx, logger, nk, entry.Key, entry.Version)
+				continue
+			}
+
+			// Running matches manage their own clocks and forfeits
+			if match, err := nk.MatchGet(ctx, gameState.MatchID); err == nil && match != nil {
+				continue
+			}
+
+			gameState.Abandon()
+
+			// Only close the game if nobody touched it since we read it
+			if err := SaveGameStateVersion(ctx, nk, gameState, objects[0].Version); err != nil {
+				logger.Debug("Skipping game %s changed during sweep: %v", gameState.MatchID, err)
+				continue
+			}
+
+			if gameState.Result == GameResultVoid {
+				voided++
+				logger.Info("Voided abandoned game - Match: %s", gameState.MatchID)
+				continue
+			}
+
+			if err := UpdatePlayerStats(ctx, logger, nk, gameState); err != nil {
+				logger.Error("Failed to update player stats for abandoned game: %v", err)
+			}
+			if err := SaveGameState(ctx, nk, gameState); err != nil {
+				logger.Error("Failed to save adjudicated game: %v", err)
+			}
+			adjudicated++
+			logger.Info("Adjudicated abandoned game - Match: %s, Winner: %s", gameState.MatchID, gameState.Winner)
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	return adjudicated, voided, nil
+}
+
+// dropActiveGame removes an index entry whose game is gone or no longer active
+func dropActiveGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, key, version string) {
+	err := nk.StorageDelete(ctx, []*runtime.StorageDelete{
+		{
+			Collection: "active_games",
+			Key:        key,
+			UserID:     "",
+			Version:    version,
+		},
+	})
+	if err != nil {
+		logger.Debug("Skipping active game index %s changed during sweep: %v", key, err)
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/heroiclabs/nakama-common/runtime"
 )
@@ -11,13 +15,75 @@ import (
 // TicTacToeMatch represents the match handler for real-time gameplay
 type TicTacToeMatch struct{}
 
+// MatchTickRate is the number of MatchLoop ticks per second
+const MatchTickRate = 10
+
 // MatchState holds the state for a match
 type MatchState struct {
-	MatchID      string                      `json:"match_id"`
-	GameState    *GameState                  `json:"game_state"`
-	PresenceList map[string]runtime.Presence `json:"-"`
+	MatchID       string                      `json:"match_id"`
+	GameState     *GameState                  `json:"game_state"`
+	PresenceList  map[string]runtime.Presence `json:"-"`
+	MoveTimeLimit int                         `json:"move_time_limit"` // Seconds per move, 0 = unlimited
+	GameTimeLimit int                         `json:"game_time_limit"` // Seconds per player per game, 0 = unlimited
+
+	// Seats held for disconnected players: user ID -> reconnect deadline (unix ms)
+	Disconnected   map[string]int64 `json:"disconnected"`
+	ReconnectGrace int              `json:"reconnect_grace"` // Seconds a dropped player may rejoin, 0 = forfeit immediately
+
+	// Matches no player is connected to are closed once EmptyTimeout passes
+	EmptySince   int64 `json:"empty_since"`   // Unix ms since when no player is connected, 0 = someone is
+	EmptyTimeout int   `json:"empty_timeout"` // Seconds, 0 = never close
+	Closed       bool  `json:"closed"`        // Set by a close signal, ends the match on the next tick
+
+	// Watchers keyed by user ID; they receive broadcasts but never hold a seat
+	Spectators    map[string]runtime.Presence `json:"-"`
+	MaxSpectators int                         `json:"max_spectators"` // 0 = unlimited
+
+	// Listing details published in the match label
+	GameMode string         `json:"game_mode"` // "casual" or "ranked"
+	Variant  BoardVariant   `json:"variant"`
+	Private  bool           `json:"private"`
+	Ratings  map[string]int `json:"ratings"` // Player ratings captured on join
+	Started  bool           `json:"started"` // Both players have joined at least once
+
+	// Private matches admit only the allow-list and holders of the join code
+	JoinCode string          `json:"join_code"`
+	Allowed  map[string]bool `json:"allowed"`
+
+	// Server-side bot, if it holds one of the seats
+	BotDifficulty string `json:"bot_difficulty"`
+	BotMoveTick   int64  `json:"bot_move_tick"` // Tick at which the bot plays its pending move, 0 = none
+
+	// Rematches played in this match
+	Rematch    map[string]bool `json:"rematch"` // Players asking for a rematch of the finished game
+	Series     SeriesScore     `json:"series"`
+	NextGameAt int64           `json:"next_game_at"` // Unix ms the next game of an undecided set starts, 0 = none
+}
+
+// MatchLabel is the JSON label published for the match, queryable through nk.MatchList
+type MatchLabel struct {
+	Mode       string `json:"mode"`
+	Status     string `json:"status"` // "waiting", "active" or "finished"
+	OpenSeats  int    `json:"open_seats"`
+	Ratings    []int  `json:"ratings"`
+	Variant    string `json:"variant"`
+	Private    bool   `json:"private"`
+	Spectators int    `json:"spectators"`
+	Series     int    `json:"series_length"` // Games in a best-of-N set, 1 = single game
 }
 
+const (
+	// DefaultReconnectGrace is how long (in seconds) a seat is held for a dropped player
+	DefaultReconnectGrace = 20
+	// DefaultMaxSpectators caps watchers per match, 0 = unlimited
+	DefaultMaxSpectators = 0
+	// DefaultEmptyTimeout is how long (in seconds) a match waits for a player before closing
+	DefaultEmptyTimeout = 120
+)
+
+// RoleSpectator is the join metadata role for watchers
+const RoleSpectator = "spectator"
+
 // OpCode represents message operation codes
 const (
 	OpCodeMove         int64 = 1
@@ -25,8 +91,35 @@ const (
 	OpCodePlayerJoined int64 = 3
 	OpCodePlayerLeft   int64 = 4
 	OpCodeGameOver     int64 = 5
+
+	OpCodeRematchRequest int64 = 6
+	OpCodeRematchAccept  int64 = 7
+	OpCodeRematchDecline int64 = 8
+	OpCodeSeries         int64 = 9
 )
 
+// Actions that RPCs can forward into a running match
+const (
+	SignalActionMove   = "move"
+	SignalActionResign = "resign"
+	SignalActionAdmit  = "admit" // Add a user to a private match's allow-list
+	SignalActionClose  = "close" // Shut down a match that has not started, e.g. when its setup failed
+)
+
+// MatchSignalRequest is an action forwarded into a running match by an RPC
+type MatchSignalRequest struct {
+	Action string `json:"action"`
+	UserID string `json:"user_id"`
+	Move   Move   `json:"move"`
+}
+
+// MatchSignalResponse reports the outcome of a forwarded action
+type MatchSignalResponse struct {
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	GameState *GameState `json:"game_state,omitempty"`
+}
+
 // MatchMessage represents a message sent in the match
 type MatchMessage struct {
 	OpCode int64           `json:"op_code"`
@@ -38,7 +131,9 @@ func (m *TicTacToeMatch) MatchInit(ctx context.Context, logger runtime.Logger, d
 	logger.Info("Match initialized")
 
 	matchID := ""
-	if id, ok := params["match_id"].(string); ok {
+	if id, ok := ctx.Value(runtime.RUNTIME_CTX_MATCH_ID).(string); ok {
+		matchID = id
+	} else if id, ok := params["match_id"].(string); ok {
 		matchID = id
 	}
 
@@ -53,23 +148,105 @@ func (m *TicTacToeMatch) MatchInit(ctx context.Context, logger runtime.Logger, d
 		logger.Info("Player 2 (O) assigned: %s", player2)
 	}
 
+	gameMode := GameModeCasual
+	if mode, ok := params["game_mode"].(string); ok && mode == GameModeRanked {
+		gameMode = mode
+	}
+	private, _ := params["private"].(bool)
+
+	// Bot games never count towards ranked ratings
+	botDifficulty, _ := params["bot_difficulty"].(string)
+	if IsBotUser(player1) || IsBotUser(player2) {
+		if !ValidBotDifficulty(botDifficulty) {
+			botDifficulty = BotDifficultyHeuristic
+		}
+		gameMode = GameModeCasual
+	} else {
+		botDifficulty = ""
+	}
+
+	seriesLength := intParam(params, "series_length", 1)
+	if !ValidSeriesLength(seriesLength) {
+		logger.Warn("Invalid series length %d, playing single games", seriesLength)
+		seriesLength = 1
+	}
+
+	variantName, _ := params["variant"].(string)
+	variant, err := LookupVariant(variantName, intParam(params, "win_length", 0))
+	if err != nil {
+		logger.Warn("Invalid variant params, using classic: %v", err)
+		variant = BoardVariants[VariantClassic]
+	}
+
 	// Create match state with player assignments
 	state := &MatchState{
-		MatchID:      matchID,
-		GameState:    nil,
-		PresenceList: make(map[string]runtime.Presence),
+		MatchID:       matchID,
+		GameState:     nil,
+		PresenceList:  make(map[string]runtime.Presence),
+		MoveTimeLimit: intParam(params, "move_time_limit", DefaultMoveTimeLimit),
+		GameTimeLimit: intParam(params, "game_time_limit", DefaultGameTimeLimit),
+
+		Disconnected:   make(map[string]int64),
+		ReconnectGrace: intParam(params, "reconnect_grace", DefaultReconnectGrace),
+
+		EmptySince:   time.Now().UnixMilli(),
+		EmptyTimeout: intParam(params, "empty_timeout", DefaultEmptyTimeout),
+
+		Spectators:    make(map[string]runtime.Presence),
+		MaxSpectators: intParam(params, "max_spectators", DefaultMaxSpectators),
+
+		GameMode: gameMode,
+		Variant:  variant,
+		Private:  private,
+		Ratings:  make(map[string]int),
+		Allowed:  make(map[string]bool),
+
+		BotDifficulty: botDifficulty,
+
+		Rematch: make(map[string]bool),
+		Series:  NewSeriesScore(seriesLength),
+	}
+
+	if private {
+		state.JoinCode, _ = params["join_code"].(string)
+		for _, userID := range stringsParam(params, "allowed") {
+			state.Allowed[userID] = true
+		}
+	}
+
+	// Ratings supplied by matchmaking are published before the players arrive
+	if player1 != "" {
+		if rating := intParam(params, "rating1", 0); rating > 0 {
+			state.Ratings[player1] = rating
+		}
+	}
+	if player2 != "" {
+		if rating := intParam(params, "rating2", 0); rating > 0 {
+			state.Ratings[player2] = rating
+		}
 	}
 
 	// If both players are assigned, we can pre-initialize the game state
 	// It will be finalized when players actually join
 	if player1 != "" && player2 != "" {
-		state.GameState = NewGameState(matchID, player1, player2, "casual")
+		state.GameState = state.NewGame(player1, player2)
+		state.GameState.FirstMove, _ = params["first_move"].(string)
+		state.GameState.BotDifficulty = state.BotDifficulty
+		if state.BotDifficulty != "" {
+			state.GameState.BotBackfill, _ = params["bot_backfill"].(bool)
+			if IsBotUser(player1) {
+				state.GameState.BotRating = intParam(params, "rating1", 0)
+			} else {
+				state.GameState.BotRating = intParam(params, "rating2", 0)
+			}
+		}
+		m.saveGameState(ctx, logger, nk, state)
 		logger.Info("Game state pre-initialized for matchmaker match")
 	}
 
 	// Tick rate: 10 times per second
-	tickRate := 10
-	label := ""
+	tickRate := MatchTickRate
+	label := state.Label()
 
 	return state, tickRate, label
 }
@@ -81,12 +258,45 @@ func (m *TicTacToeMatch) MatchJoinAttempt(ctx context.Context, logger runtime.Lo
 		return state, false, "invalid match state"
 	}
 
+	userID := presence.GetUserId()
+
+	// Private matches turn away anyone not invited, admitted or holding the code
+	if matchState.Private && !matchState.Admits(userID, metadata["code"]) {
+		return state, false, "match is private"
+	}
+
+	// Spectators never take a seat, so they are checked against their own cap.
+	// They are only recorded once MatchJoin confirms them.
+	if metadata["role"] == RoleSpectator && !matchState.IsPlayer(userID) {
+		if matchState.GameState == nil {
+			return state, false, "match has not started"
+		}
+		if matchState.MaxSpectators > 0 && len(matchState.Spectators) >= matchState.MaxSpectators {
+			return state, false, "too many spectators"
+		}
+		logger.Info("Spectator attempting to join - UserID: %s", userID)
+		return state, true, ""
+	}
+
+	// Once the players are known their seats are reserved, so only they may (re)join
+	if gameState := matchState.GameState; gameState != nil {
+		if userID != gameState.PlayerX && userID != gameState.PlayerO {
+			return state, false, "match is full"
+		}
+		if _, ok := matchState.Disconnected[userID]; ok {
+			logger.Info("Player attempting to reconnect - UserID: %s", userID)
+		} else {
+			logger.Info("Player attempting to join - UserID: %s", userID)
+		}
+		return state, true, ""
+	}
+
 	// Allow up to 2 players
 	if len(matchState.PresenceList) >= 2 {
 		return state, false, "match is full"
 	}
 
-	logger.Info("Player attempting to join - UserID: %s", presence.GetUserId())
+	logger.Info("Player attempting to join - UserID: %s", userID)
 	return state, true, ""
 }
 
@@ -98,8 +308,43 @@ func (m *TicTacToeMatch) MatchJoin(ctx context.Context, logger runtime.Logger, d
 	}
 
 	for _, presence := range presences {
+		// Once the seats are assigned, anyone else let in is a spectator
+		if matchState.GameState != nil && !matchState.IsPlayer(presence.GetUserId()) {
+			if _, ok := matchState.Spectators[presence.GetUserId()]; !ok &&
+				matchState.MaxSpectators > 0 && len(matchState.Spectators) >= matchState.MaxSpectators {
+				// Another spectator took the last place since this one's join attempt
+				dispatcher.MatchKick([]runtime.Presence{presence})
+				continue
+			}
+
+			matchState.Spectators[presence.GetUserId()] = presence
+			logger.Info("Spectator joined match - UserID: %s, Total spectators: %d", presence.GetUserId(), len(matchState.Spectators))
+
+			// Catch the spectator up on the game so far
+			m.sendGameState(dispatcher, matchState.GameState, []runtime.Presence{presence})
+			continue
+		}
+
 		matchState.PresenceList[presence.GetUserId()] = presence
-		logger.Info("Player joined match - UserID: %s, Total players: %d", presence.GetUserId(), len(matchState.PresenceList))
+		matchState.EmptySince = 0
+
+		if _, ok := matchState.Disconnected[presence.GetUserId()]; ok {
+			delete(matchState.Disconnected, presence.GetUserId())
+			logger.Info("Player reconnected - UserID: %s", presence.GetUserId())
+
+			// Bring the returning player up to date
+			m.sendGameState(dispatcher, matchState.GameState, []runtime.Presence{presence})
+		} else {
+			logger.Info("Player joined match - UserID: %s, Total players: %d", presence.GetUserId(), len(matchState.PresenceList))
+		}
+
+		if _, ok := matchState.Ratings[presence.GetUserId()]; !ok {
+			if profile, err := GetUserProfile(ctx, logger, nk, presence.GetUserId()); err == nil {
+				matchState.Ratings[presence.GetUserId()] = profile.Rating
+			} else {
+				logger.Warn("Failed to load profile for match label: %v", err)
+			}
+		}
 
 		// Broadcast player joined event
 		message := map[string]interface{}{
@@ -118,35 +363,44 @@ func (m *TicTacToeMatch) MatchJoin(ctx context.Context, logger runtime.Logger, d
 	}
 
 	// If both players are present, ensure game state is ready
-	if len(matchState.PresenceList) == 2 {
+	if len(matchState.PresenceList) == matchState.SeatsToFill() && !matchState.Started {
+		matchState.Started = true
+
+		// Both seats are taken, so the join code has done its job
+		if matchState.JoinCode != "" {
+			DeleteJoinCode(ctx, logger, nk, matchState.JoinCode)
+		}
+
 		// If game state was pre-initialized by matchmaker, just broadcast it
 		if matchState.GameState != nil {
 			logger.Info("Both players joined matchmaker match - Match ID: %s", matchState.MatchID)
+			matchState.GameState.StartTurnClock(time.Now())
 			m.broadcastGameState(dispatcher, matchState.GameState)
 		} else {
 			// Manual match creation (fallback for non-matchmaker matches)
-			players := make([]string, 0, 2)
+			players := make([]MatchPlayer, 0, 2)
 			for userID := range matchState.PresenceList {
-				players = append(players, userID)
+				players = append(players, MatchPlayer{UserID: userID, Rating: matchState.Ratings[userID]})
 			}
+			x, o, firstMove := AssignSymbols(ctx, logger, nk, players[0], players[1])
 
 			// Generate match ID if not set
 			if matchState.MatchID == "" {
-				matchState.MatchID = "match_" + players[0] + "_" + players[1]
+				matchState.MatchID = "match_" + x.UserID + "_" + o.UserID
 			}
 
-			matchState.GameState = NewGameState(
-				matchState.MatchID,
-				players[0],
-				players[1],
-				"casual", // Default to casual
-			)
+			matchState.GameState = matchState.NewGame(x.UserID, o.UserID)
+			matchState.GameState.FirstMove = firstMove
+			matchState.GameState.StartTurnClock(time.Now())
+			m.saveGameState(ctx, logger, nk, matchState)
 
 			logger.Info("Game started (manual match) - Match ID: %s", matchState.MatchID)
 			m.broadcastGameState(dispatcher, matchState.GameState)
 		}
 	}
 
+	m.updateLabel(logger, dispatcher, matchState)
+
 	return matchState
 }
 
@@ -157,14 +411,43 @@ func (m *TicTacToeMatch) MatchLeave(ctx context.Context, logger runtime.Logger,
 		return state
 	}
 
+	// Games are pre-created for matchmaker, challenge and bot matches, so only a
+	// game both players have joined can be forfeited
+	gameActive := matchState.Started && matchState.GameState != nil && matchState.GameState.Status == GameStatusActive
+
+	playersLeft := make([]runtime.Presence, 0, len(presences))
 	for _, presence := range presences {
-		delete(matchState.PresenceList, presence.GetUserId())
-		logger.Info("Player left match - UserID: %s", presence.GetUserId())
+		userID := presence.GetUserId()
+
+		if _, ok := matchState.Spectators[userID]; ok {
+			delete(matchState.Spectators, userID)
+			logger.Info("Spectator left match - UserID: %s", userID)
+			continue
+		}
+		if !matchState.IsPlayer(userID) {
+			// A spectator kicked for going over the cap never held a seat
+			continue
+		}
+		playersLeft = append(playersLeft, presence)
+
+		delete(matchState.PresenceList, userID)
+		logger.Info("Player left match - UserID: %s", userID)
 
 		// Broadcast player left event
 		message := map[string]interface{}{
-			"user_id": presence.GetUserId(),
+			"user_id": userID,
 		}
+
+		// Hold the seat so a dropped connection doesn't cost the game
+		if gameActive {
+			deadline := time.Now().Add(time.Duration(matchState.ReconnectGrace) * time.Second).UnixMilli()
+			matchState.Disconnected[userID] = deadline
+			if matchState.ReconnectGrace > 0 {
+				message["reconnect_deadline"] = deadline
+				logger.Info("Holding seat for %d seconds - UserID: %s", matchState.ReconnectGrace, userID)
+			}
+		}
+
 		messageJSON, _ := json.Marshal(message)
 
 		envelope := &MatchMessage{
@@ -176,38 +459,23 @@ func (m *TicTacToeMatch) MatchLeave(ctx context.Context, logger runtime.Logger,
 		dispatcher.BroadcastMessage(OpCodePlayerLeft, envelopeJSON, nil, nil, true)
 	}
 
-	// If a player leaves during an active game, declare opponent as winner
-	if matchState.GameState != nil && matchState.GameState.Status == GameStatusActive && len(matchState.PresenceList) < 2 {
-		// Find remaining player
-		for userID := range matchState.PresenceList {
-			matchState.GameState.Status = GameStatusFinished
-			matchState.GameState.Winner = userID
-
-			if userID == matchState.GameState.PlayerX {
-				matchState.GameState.Result = GameResultXWins
-			} else {
-				matchState.GameState.Result = GameResultOWins
-			}
-
-			logger.Info("Game ended due to player disconnect - Winner: %s", userID)
+	if len(matchState.PresenceList) == 0 && matchState.EmptySince == 0 {
+		matchState.EmptySince = time.Now().UnixMilli()
+	}
 
-			// Update player stats and calculate rating changes BEFORE broadcasting
-			if err := UpdatePlayerStats(ctx, logger, nk, matchState.GameState); err != nil {
-				logger.Error("Failed to update player stats after disconnect: %v", err)
-			}
+	m.updateLabel(logger, dispatcher, matchState)
 
-			// Now broadcast with rating changes included
-			m.broadcastGameState(dispatcher, matchState.GameState)
+	// A player leaving after the game withdraws any pending rematch
+	if len(playersLeft) > 0 && len(matchState.Rematch) > 0 && matchState.GameState != nil && matchState.GameState.Status == GameStatusFinished {
+		matchState.Rematch = make(map[string]bool)
+		m.broadcastRematch(dispatcher, matchState, OpCodeRematchDecline, playersLeft[0].GetUserId())
+	}
 
-			// Broadcast Game Over OpCode (5)
-			stateJSON, _ := json.Marshal(matchState.GameState)
-			envelope := &MatchMessage{
-				OpCode: OpCodeGameOver,
-				Data:   stateJSON,
-			}
-			envelopeJSON, _ := json.Marshal(envelope)
-			dispatcher.BroadcastMessage(OpCodeGameOver, envelopeJSON, nil, nil, true)
-			break
+	// Without a grace window, the player who left first forfeits at once
+	if gameActive && matchState.ReconnectGrace <= 0 {
+		if userID, _ := matchState.FirstDisconnected(); userID != "" {
+			delete(matchState.Disconnected, userID)
+			m.forfeitDisconnected(ctx, logger, nk, dispatcher, matchState, userID)
 		}
 	}
 
@@ -221,16 +489,79 @@ func (m *TicTacToeMatch) MatchLoop(ctx context.Context, logger runtime.Logger, d
 		return state
 	}
 
+	if matchState.Closed {
+		logger.Info("Closing match - Match ID: %s", matchState.MatchID)
+		return nil
+	}
+
 	// Process incoming messages
 	for _, message := range messages {
 		switch message.GetOpCode() {
 		case OpCodeMove:
-			m.handleMove(ctx, logger, nk, dispatcher, matchState, message)
+			if !matchState.IsPlayer(message.GetUserId()) {
+				logger.Debug("Ignoring move from non-player - UserID: %s", message.GetUserId())
+				continue
+			}
+			m.handleMove(ctx, logger, nk, dispatcher, matchState, message, tick)
+		case OpCodeRematchRequest, OpCodeRematchAccept, OpCodeRematchDecline:
+			if !matchState.IsPlayer(message.GetUserId()) {
+				continue
+			}
+			if err := m.handleRematch(ctx, logger, nk, dispatcher, matchState, message.GetOpCode(), message.GetUserId()); err != nil {
+				logger.Warn("Invalid rematch message from %s: %v", message.GetUserId(), err)
+			}
+		}
+	}
+
+	// Forfeit players whose reconnect window has expired
+	if matchState.GameState != nil && len(matchState.Disconnected) > 0 {
+		if matchState.GameState.Status != GameStatusActive {
+			matchState.Disconnected = make(map[string]int64)
+		} else if userID, deadline := matchState.FirstDisconnected(); time.Now().UnixMilli() >= deadline {
+			// The player who left first loses, unless both players are gone
+			if len(matchState.PresenceList) == 0 && !matchState.GameState.HasBot() {
+				m.voidGame(ctx, logger, nk, dispatcher, matchState)
+			} else {
+				delete(matchState.Disconnected, userID)
+				m.forfeitDisconnected(ctx, logger, nk, dispatcher, matchState, userID)
+			}
+		}
+	}
+
+	// Start the next game of an undecided set once the pause is over
+	if matchState.NextGameAt > 0 && time.Now().UnixMilli() >= matchState.NextGameAt {
+		m.startSeriesGame(ctx, logger, nk, dispatcher, matchState)
+	}
+
+	m.playBotTurn(ctx, logger, nk, dispatcher, matchState, tick)
+
+	// Enforce the move clock
+	if gameState := matchState.GameState; gameState != nil && gameState.Status == GameStatusActive && gameState.IsTimed() {
+		if gameState.CheckTimeout(time.Now()) {
+			logger.Info("Game ended on time - Match ID: %s, Winner: %s", matchState.MatchID, gameState.Winner)
+			m.finishGame(ctx, logger, nk, dispatcher, matchState)
+		} else if tick%MatchTickRate == 0 {
+			// Keep clients' clocks in sync once per second
+			m.broadcastGameState(dispatcher, gameState)
 		}
 	}
 
+	// Close a match no player has been in for too long, e.g. one nobody ever joined.
+	// Held seats are left to the reconnect deadline above.
+	if matchState.EmptyTimeout > 0 && matchState.EmptySince > 0 && len(matchState.Disconnected) == 0 &&
+		time.Now().UnixMilli()-matchState.EmptySince >= int64(matchState.EmptyTimeout)*1000 {
+		if matchState.GameState != nil && matchState.GameState.Status == GameStatusActive {
+			m.voidGame(ctx, logger, nk, dispatcher, matchState)
+		}
+		if matchState.JoinCode != "" && !matchState.Started {
+			DeleteJoinCode(ctx, logger, nk, matchState.JoinCode)
+		}
+		logger.Info("Closing empty match - Match ID: %s", matchState.MatchID)
+		return nil
+	}
+
 	// End match if game is finished and no players remain
-	if matchState.GameState != nil && matchState.GameState.Status == GameStatusFinished && len(matchState.PresenceList) == 0 {
+	if matchState.GameState != nil && matchState.GameState.Status == GameStatusFinished && len(matchState.PresenceList) == 0 && matchState.NextGameAt == 0 {
 		return nil
 	}
 
@@ -246,18 +577,46 @@ func (m *TicTacToeMatch) MatchTerminate(ctx context.Context, logger runtime.Logg
 	return state
 }
 
-// MatchSignal handles custom signals sent to the match
+// MatchSignal handles actions forwarded into the match by RPCs
 func (m *TicTacToeMatch) MatchSignal(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, tick int64, state interface{}, data string) (interface{}, string) {
-	return state, ""
-}
+	matchState, ok := state.(*MatchState)
+	if !ok {
+		return state, ""
+	}
 
-// handleMove processes a move message from a player
-func (m *TicTacToeMatch) handleMove(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState, message runtime.MatchData) {
-	if matchState.GameState == nil {
-		logger.Warn("Received move but game state is nil")
-		return
+	var request MatchSignalRequest
+	if err := json.Unmarshal([]byte(data), &request); err != nil {
+		logger.Error("Failed to unmarshal match signal: %v", err)
+		return matchState, signalResponse(matchState, fmt.Errorf("invalid signal"))
+	}
+
+	// Admission comes before the player holds a seat
+	if request.Action == SignalActionAdmit {
+		return matchState, signalResponse(matchState, matchState.Admit(request.UserID))
+	}
+	if request.Action == SignalActionClose {
+		return matchState, signalResponse(matchState, matchState.Close())
 	}
 
+	if !matchState.IsPlayer(request.UserID) {
+		return matchState, signalResponse(matchState, fmt.Errorf("not a player in this match"))
+	}
+
+	var err error
+	switch request.Action {
+	case SignalActionMove:
+		err = m.applyMove(ctx, logger, nk, dispatcher, matchState, request.UserID, request.Move, tick)
+	case SignalActionResign:
+		err = m.resign(ctx, logger, nk, dispatcher, matchState, request.UserID)
+	default:
+		err = fmt.Errorf("unknown action: %s", request.Action)
+	}
+
+	return matchState, signalResponse(matchState, err)
+}
+
+// handleMove processes a move message from a player
+func (m *TicTacToeMatch) handleMove(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState, message runtime.MatchData, tick int64) {
 	var move Move
 	if err := json.Unmarshal(message.GetData(), &move); err != nil {
 		logger.Error("Failed to unmarshal move: %v", err)
@@ -265,43 +624,292 @@ func (m *TicTacToeMatch) handleMove(ctx context.Context, logger runtime.Logger,
 	}
 
 	userID := message.GetUserId()
+	if err := m.applyMove(ctx, logger, nk, dispatcher, matchState, userID, move, tick); err != nil {
+		logger.Warn("Invalid move from %s: %v", userID, err)
+	}
+}
+
+// applyMove validates and applies a move, then persists and broadcasts the result
+func (m *TicTacToeMatch) applyMove(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState, userID string, move Move, tick int64) error {
+	if matchState.GameState == nil {
+		return fmt.Errorf("game has not started")
+	}
+
+	now := time.Now()
+
+	// A move that arrives after the clock ran out is not accepted
+	if matchState.GameState.CheckTimeout(now) {
+		logger.Info("Move from %s rejected, clock expired", userID)
+		m.finishGame(ctx, logger, nk, dispatcher, matchState)
+		return fmt.Errorf("time expired")
+	}
 
 	// Apply the move
-	if err := matchState.GameState.ApplyMove(move.Row, move.Col, userID); err != nil {
-		logger.Warn("Invalid move from %s: %v", userID, err)
-		return
+	mover := matchState.GameState.CurrentPlayer
+	if err := matchState.GameState.PlayMove(move, userID, now, tick); err != nil {
+		return err
 	}
+	matchState.GameState.EndTurnClock(mover, now)
 
-	logger.Info("Move applied - UserID: %s, Position: (%d,%d)", userID, move.Row, move.Col)
+	logger.Info("Move applied - UserID: %s, Move: %+v", userID, move)
 
-	// If game is finished, update stats FIRST before broadcasting
+	// If game is finished, update stats and announce the result
 	if matchState.GameState.Status == GameStatusFinished {
 		logger.Info("Game finished - Result: %s, Winner: %s", matchState.GameState.Result, matchState.GameState.Winner)
+		m.finishGame(ctx, logger, nk, dispatcher, matchState)
+		return nil
+	}
+
+	m.saveGameState(ctx, logger, nk, matchState)
+	m.broadcastGameState(dispatcher, matchState.GameState)
+	return nil
+}
+
+// playBotTurn makes the bot's move once it has thought for BotThinkTicks
+func (m *TicTacToeMatch) playBotTurn(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState, tick int64) {
+	gameState := matchState.GameState
+	if gameState == nil || !matchState.Started || gameState.Status != GameStatusActive || !IsBotUser(gameState.CurrentPlayerID()) {
+		matchState.BotMoveTick = 0
+		return
+	}
+
+	if matchState.BotMoveTick == 0 {
+		matchState.BotMoveTick = tick + BotThinkTicks
+		return
+	}
+	if tick < matchState.BotMoveTick {
+		return
+	}
+	matchState.BotMoveTick = 0
 
-		// Update player stats and calculate rating changes BEFORE broadcasting
+	move, ok := ChooseBotMove(gameState, matchState.BotDifficulty)
+	if !ok {
+		return
+	}
+	if err := m.applyMove(ctx, logger, nk, dispatcher, matchState, BotUserID, move, tick); err != nil {
+		logger.Error("Bot move rejected: %v", err)
+	}
+}
+
+// resign ends an active game in favour of the resigning player's opponent
+func (m *TicTacToeMatch) resign(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState, userID string) error {
+	if matchState.GameState == nil || matchState.GameState.Status != GameStatusActive {
+		return fmt.Errorf("game is not active")
+	}
+
+	matchState.GameState.Forfeit(userID)
+	logger.Info("Player resigned - Match: %s, Player: %s", matchState.MatchID, userID)
+
+	m.finishGame(ctx, logger, nk, dispatcher, matchState)
+	return nil
+}
+
+// IsPlayer reports whether the user holds (or is about to hold) a seat in the match
+func (ms *MatchState) IsPlayer(userID string) bool {
+	if ms.GameState != nil {
+		return userID == ms.GameState.PlayerX || userID == ms.GameState.PlayerO
+	}
+	_, ok := ms.PresenceList[userID]
+	return ok
+}
+
+// NewGame creates a game between two players with the match's variant, clocks and set length
+func (ms *MatchState) NewGame(playerX, playerO string) *GameState {
+	gameState := NewGameState(ms.MatchID, playerX, playerO, ms.GameMode, ms.Variant)
+	gameState.SetTimeControl(ms.MoveTimeLimit, ms.GameTimeLimit)
+	if ms.Series.IsSeries() {
+		gameState.SeriesLength = ms.Series.Length
+	}
+	return gameState
+}
+
+// FirstDisconnected returns the held seat whose reconnect deadline comes first
+func (ms *MatchState) FirstDisconnected() (string, int64) {
+	first, earliest := "", int64(0)
+	for userID, deadline := range ms.Disconnected {
+		if first == "" || deadline < earliest || (deadline == earliest && userID < first) {
+			first, earliest = userID, deadline
+		}
+	}
+	return first, earliest
+}
+
+// Admits reports whether a user may enter a private match
+func (ms *MatchState) Admits(userID, code string) bool {
+	if ms.Allowed[userID] || ms.IsPlayer(userID) {
+		return true
+	}
+	return code != "" && ms.JoinCode != "" && strings.EqualFold(code, ms.JoinCode)
+}
+
+// Admit adds a user to a private match's allow-list
+func (ms *MatchState) Admit(userID string) error {
+	if !ms.Private {
+		return fmt.Errorf("match is not private")
+	}
+	if ms.GameState != nil && ms.GameState.Status != GameStatusActive {
+		return fmt.Errorf("game is over")
+	}
+	ms.Allowed[userID] = true
+	return nil
+}
+
+// Close marks a match that has not started to end on the next tick
+func (ms *MatchState) Close() error {
+	if ms.Started {
+		return fmt.Errorf("match has started")
+	}
+	ms.Closed = true
+	return nil
+}
+
+// SeatsToFill returns how many players must join before the game starts
+func (ms *MatchState) SeatsToFill() int {
+	if ms.BotDifficulty != "" {
+		return 1
+	}
+	return 2
+}
+
+// Label builds the JSON match label
+func (ms *MatchState) Label() string {
+	label := MatchLabel{
+		Mode:       ms.GameMode,
+		Status:     string(GameStatusWaiting),
+		OpenSeats:  0,
+		Ratings:    make([]int, 0, 2),
+		Variant:    ms.Variant.Name,
+		Private:    ms.Private,
+		Spectators: len(ms.Spectators),
+		Series:     ms.Series.Length,
+	}
+
+	if ms.GameState != nil {
+		// Seats are reserved for the assigned players, so a pre-initialized
+		// game only counts as live once both have arrived
+		if ms.Started || ms.GameState.Status != GameStatusActive {
+			label.Status = string(ms.GameState.Status)
+		}
+		for _, userID := range []string{ms.GameState.PlayerX, ms.GameState.PlayerO} {
+			if rating, ok := ms.Ratings[userID]; ok {
+				label.Ratings = append(label.Ratings, rating)
+			}
+		}
+	} else {
+		label.OpenSeats = 2 - len(ms.PresenceList)
+		for userID := range ms.PresenceList {
+			if rating, ok := ms.Ratings[userID]; ok {
+				label.Ratings = append(label.Ratings, rating)
+			}
+		}
+		sort.Ints(label.Ratings)
+	}
+
+	data, err := json.Marshal(label)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// updateLabel publishes the current match label
+func (m *TicTacToeMatch) updateLabel(logger runtime.Logger, dispatcher runtime.MatchDispatcher, matchState *MatchState) {
+	if err := dispatcher.MatchLabelUpdate(matchState.Label()); err != nil {
+		logger.Error("Failed to update match label: %v", err)
+	}
+}
+
+// forfeitDisconnected ends an active game in favour of the opponent of a player who left
+func (m *TicTacToeMatch) forfeitDisconnected(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState, userID string) {
+	if matchState.GameState == nil || matchState.GameState.Status != GameStatusActive {
+		return
+	}
+	if userID != matchState.GameState.PlayerX && userID != matchState.GameState.PlayerO {
+		return
+	}
+
+	matchState.GameState.Forfeit(userID)
+	logger.Info("Game ended due to player disconnect - Winner: %s", matchState.GameState.Winner)
+
+	m.finishGame(ctx, logger, nk, dispatcher, matchState)
+}
+
+// voidGame ends an active game without a result, e.g. when nobody is left to play it
+func (m *TicTacToeMatch) voidGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState) {
+	matchState.GameState.Void()
+	matchState.Disconnected = make(map[string]int64)
+	logger.Info("Game voided - Match ID: %s", matchState.MatchID)
+
+	m.finishGame(ctx, logger, nk, dispatcher, matchState)
+}
+
+// finishGame records the result of a finished game and broadcasts it to all players
+func (m *TicTacToeMatch) finishGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState) {
+	matchState.Series.Record(matchState.GameState)
+
+	// Update player stats and calculate rating changes BEFORE broadcasting
+	switch {
+	case matchState.GameState.Result == GameResultVoid:
+		// Voided games leave ratings, records and history untouched
+	case matchState.Series.IsSeries():
+		m.recordSeriesGame(ctx, logger, nk, matchState)
+	default:
 		if err := UpdatePlayerStats(ctx, logger, nk, matchState.GameState); err != nil {
 			logger.Error("Failed to update player stats: %v", err)
 		}
 	}
+	m.saveGameState(ctx, logger, nk, matchState)
 
-	// Broadcast updated game state (now includes rating changes if game finished)
+	// Broadcast updated game state (now includes rating changes)
 	m.broadcastGameState(dispatcher, matchState.GameState)
 
-	// If game is finished, also broadcast Game Over OpCode (5)
-	if matchState.GameState.Status == GameStatusFinished {
-		// Broadcast Game Over OpCode (5) with rating changes included
-		stateJSON, _ := json.Marshal(matchState.GameState)
-		envelope := &MatchMessage{
-			OpCode: OpCodeGameOver,
-			Data:   stateJSON,
-		}
-		envelopeJSON, _ := json.Marshal(envelope)
-		dispatcher.BroadcastMessage(OpCodeGameOver, envelopeJSON, nil, nil, true)
+	// Broadcast Game Over OpCode (5) with rating changes included
+	stateJSON, _ := json.Marshal(matchState.GameState)
+	envelope := &MatchMessage{
+		OpCode: OpCodeGameOver,
+		Data:   stateJSON,
+	}
+	envelopeJSON, _ := json.Marshal(envelope)
+	dispatcher.BroadcastMessage(OpCodeGameOver, envelopeJSON, nil, nil, true)
+
+	// Keep players up to date on the set
+	if matchState.Series.IsSeries() {
+		seriesJSON, _ := json.Marshal(SeriesMessage{
+			Series:     matchState.Series,
+			NextGameAt: matchState.NextGameAt,
+		})
+		envelopeJSON, _ := json.Marshal(&MatchMessage{
+			OpCode: OpCodeSeries,
+			Data:   seriesJSON,
+		})
+		dispatcher.BroadcastMessage(OpCodeSeries, envelopeJSON, nil, nil, true)
+	}
+
+	m.updateLabel(logger, dispatcher, matchState)
+}
+
+// saveGameState persists the game to the games collection so RPCs can read it
+func (m *TicTacToeMatch) saveGameState(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, matchState *MatchState) {
+	if matchState.GameState == nil {
+		return
+	}
+	if err := SaveGameState(ctx, nk, matchState.GameState); err != nil {
+		logger.Error("Failed to save game state: %v", err)
 	}
 }
 
 // broadcastGameState sends the current game state to all players
 func (m *TicTacToeMatch) broadcastGameState(dispatcher runtime.MatchDispatcher, gameState *GameState) {
+	m.sendGameState(dispatcher, gameState, nil)
+}
+
+// sendGameState sends the current game state to the given presences, or to everyone if nil
+func (m *TicTacToeMatch) sendGameState(dispatcher runtime.MatchDispatcher, gameState *GameState, presences []runtime.Presence) {
+	if gameState == nil {
+		return
+	}
+	gameState.UpdateTurnTimeLeft(time.Now())
+
 	stateJSON, err := json.Marshal(gameState)
 	if err != nil {
 		return
@@ -313,5 +921,34 @@ func (m *TicTacToeMatch) broadcastGameState(dispatcher runtime.MatchDispatcher,
 	}
 	envelopeJSON, _ := json.Marshal(envelope)
 
-	dispatcher.BroadcastMessage(OpCodeGameState, envelopeJSON, nil, nil, true)
+	dispatcher.BroadcastMessage(OpCodeGameState, envelopeJSON, presences, nil, true)
+}
+
+// intParam reads an integer match parameter, accepting the numeric types
+// produced by both Go callers and JSON-decoded payloads
+func intParam(params map[string]interface{}, key string, defaultValue int) int {
+	switch v := params[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return defaultValue
+}
+
+// signalResponse encodes the outcome of a match signal
+func signalResponse(matchState *MatchState, err error) string {
+	response := MatchSignalResponse{
+		Success:   err == nil,
+		Message:   "ok",
+		GameState: matchState.GameState,
+	}
+	if err != nil {
+		response.Message = err.Error()
+	}
+
+	data, _ := json.Marshal(response)
+	return string(data)
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// fakePresence is a connected user
+type fakePresence struct {
+	runtime.Presence
+	userID string
+}
+
+func (p fakePresence) GetUserId() string    { return p.userID }
+func (p fakePresence) GetUsername() string  { return p.userID }
+func (p fakePresence) GetSessionId() string { return "session-" + p.userID }
+
+// fakeDispatcher drops everything sent to the match's presences
+type fakeDispatcher struct {
+	runtime.MatchDispatcher
+}
+
+func (fakeDispatcher) BroadcastMessage(opCode int64, data []byte, presences []runtime.Presence, sender runtime.Presence, reliable bool) error {
+	return nil
+}
+func (fakeDispatcher) MatchKick(presences []runtime.Presence) error { return nil }
+func (fakeDispatcher) MatchLabelUpdate(label string) error          { return nil }
+
+// testMatch drives a TicTacToeMatch between the pre-assigned players "x" and "o"
+type testMatch struct {
+	t     *testing.T
+	nk    *fakeNakama
+	match *TicTacToeMatch
+	state *MatchState
+	tick  int64
+}
+
+func newTestMatch(t *testing.T, reconnectGrace int) *testMatch {
+	t.Helper()
+
+	tm := &testMatch{t: t, nk: newFakeNakama(), match: &TicTacToeMatch{}}
+	state, _, _ := tm.match.MatchInit(context.Background(), fakeLogger{}, nil, tm.nk, map[string]interface{}{
+		"match_id":        "match-1",
+		"player1":         "x",
+		"player2":         "o",
+		"reconnect_grace": reconnectGrace,
+		"first_move":      "x",
+	})
+	tm.state = state.(*MatchState)
+	return tm
+}
+
+func (tm *testMatch) join(userID string) {
+	tm.t.Helper()
+
+	presence := fakePresence{userID: userID}
+	if _, ok, reason := tm.match.MatchJoinAttempt(context.Background(), fakeLogger{}, nil, tm.nk, fakeDispatcher{}, tm.tick, tm.state, presence, nil); !ok {
+		tm.t.Fatalf("%s could not join: %s", userID, reason)
+	}
+	tm.match.MatchJoin(context.Background(), fakeLogger{}, nil, tm.nk, fakeDispatcher{}, tm.tick, tm.state, []runtime.Presence{presence})
+}
+
+func (tm *testMatch) leave(userIDs ...string) {
+	presences := make([]runtime.Presence, 0, len(userIDs))
+	for _, userID := range userIDs {
+		presences = append(presences, fakePresence{userID: userID})
+	}
+	tm.match.MatchLeave(context.Background(), fakeLogger{}, nil, tm.nk, fakeDispatcher{}, tm.tick, tm.state, presences)
+}
+
+// loop runs one tick and reports whether the match is still running
+func (tm *testMatch) loop() bool {
+	tm.tick++
+	return tm.match.MatchLoop(context.Background(), fakeLogger{}, nil, tm.nk, fakeDispatcher{}, tm.tick, tm.state, nil) != nil
+}
+
+// expireHeldSeats moves every reconnect deadline into the past
+func (tm *testMatch) expireHeldSeats() {
+	for userID := range tm.state.Disconnected {
+		tm.state.Disconnected[userID] = time.Now().Add(-time.Second).UnixMilli()
+	}
+}
+
+func (tm *testMatch) move(userID string, row, col int) error {
+	return tm.match.applyMove(context.Background(), fakeLogger{}, tm.nk, fakeDispatcher{}, tm.state, userID, Move{Row: row, Col: col}, tm.tick)
+}
+
+func TestMatchLeaveBeforeOpponentArrivesCanRejoin(t *testing.T) {
+	tm := newTestMatch(t, DefaultReconnectGrace)
+
+	tm.join("x")
+	tm.leave("x")
+	if !tm.loop() {
+		t.Fatal("match closed as soon as the first player dropped")
+	}
+	if tm.state.GameState.Status != GameStatusActive {
+		t.Fatalf("game ended before the opponent arrived: %s", tm.state.GameState.Result)
+	}
+
+	tm.join("x")
+	tm.join("o")
+	if !tm.state.Started {
+		t.Fatal("game did not start once both players were in")
+	}
+	if err := tm.move("x", 1, 1); err != nil {
+		t.Fatalf("game is not playable after the rejoin: %v", err)
+	}
+	if !tm.loop() {
+		t.Fatal("match closed during play")
+	}
+}
+
+func TestMatchLeaveBeforeOpponentArrivesVoidsOnceEmpty(t *testing.T) {
+	tm := newTestMatch(t, DefaultReconnectGrace)
+
+	tm.join("x")
+	tm.leave("x")
+	tm.state.EmptySince = time.Now().Add(-time.Duration(tm.state.EmptyTimeout+1) * time.Second).UnixMilli()
+	if tm.loop() {
+		t.Fatal("empty match was not closed")
+	}
+	if tm.state.GameState.Result != GameResultVoid {
+		t.Fatalf("expected the unstarted game to be voided, got %s", tm.state.GameState.Result)
+	}
+}
+
+func TestMatchLeaveGraceAndVoid(t *testing.T) {
+	tests := []struct {
+		name       string
+		grace      int
+		leave      [][]string // Players leaving, one MatchLeave call each
+		expire     bool       // Run past the reconnect deadlines
+		wantStatus GameStatus
+		wantResult GameResult
+		wantWinner string
+	}{
+		{
+			name:       "seat held within the grace window",
+			grace:      DefaultReconnectGrace,
+			leave:      [][]string{{"x"}},
+			wantStatus: GameStatusActive,
+			wantResult: GameResultNone,
+		},
+		{
+			name:       "forfeit once the grace window expires",
+			grace:      DefaultReconnectGrace,
+			leave:      [][]string{{"x"}},
+			expire:     true,
+			wantStatus: GameStatusFinished,
+			wantResult: GameResultOWins,
+			wantWinner: "o",
+		},
+		{
+			name:       "instant forfeit without a grace window",
+			grace:      0,
+			leave:      [][]string{{"o"}},
+			wantStatus: GameStatusFinished,
+			wantResult: GameResultXWins,
+			wantWinner: "x",
+		},
+		{
+			name:       "first to leave forfeits without a grace window",
+			grace:      0,
+			leave:      [][]string{{"x"}, {"o"}},
+			wantStatus: GameStatusFinished,
+			wantResult: GameResultOWins,
+			wantWinner: "o",
+		},
+		{
+			name:       "void when neither player returns",
+			grace:      DefaultReconnectGrace,
+			leave:      [][]string{{"x"}, {"o"}},
+			expire:     true,
+			wantStatus: GameStatusFinished,
+			wantResult: GameResultVoid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTestMatch(t, tt.grace)
+			tm.join("x")
+			tm.join("o")
+
+			for _, userIDs := range tt.leave {
+				tm.leave(userIDs...)
+			}
+			if tt.expire {
+				tm.expireHeldSeats()
+			}
+			tm.loop()
+
+			gs := tm.state.GameState
+			if gs.Status != tt.wantStatus || gs.Result != tt.wantResult || gs.Winner != tt.wantWinner {
+				t.Fatalf("got status %s, result %s, winner %q; want %s, %s, %q",
+					gs.Status, gs.Result, gs.Winner, tt.wantStatus, tt.wantResult, tt.wantWinner)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// ListMatchesRequest represents a request to browse real-time matches
+type ListMatchesRequest struct {
+	Filter   string `json:"filter"`              // "open" (default), "live" or "all"
+	GameMode string `json:"game_mode,omitempty"` // "casual" or "ranked"
+	Variant  string `json:"variant,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+}
+
+// MatchListing represents a single match in the listing
+type MatchListing struct {
+	MatchID string     `json:"match_id"`
+	Size    int32      `json:"size"`
+	Label   MatchLabel `json:"label"`
+}
+
+// ListMatchesResponse represents the match listing response
+type ListMatchesResponse struct {
+	Matches []MatchListing `json:"matches"`
+}
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// labelTermPattern restricts user input embedded in label queries
+var labelTermPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// RpcListMatches lists public matches that are open to join or live to spectate
+func RpcListMatches(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var request ListMatchesRequest
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &request); err != nil {
+			logger.Error("Failed to unmarshal request: %v", err)
+			return "", runtime.NewError("invalid request payload", 3)
+		}
+	}
+
+	if request.Limit <= 0 {
+		request.Limit = defaultListLimit
+	}
+	if request.Limit > maxListLimit {
+		request.Limit = maxListLimit
+	}
+
+	query, err := buildMatchQuery(&request)
+	if err != nil {
+		return "", err
+	}
+
+	matches, err := nk.MatchList(ctx, request.Limit, true, "", nil, nil, query)
+	if err != nil {
+		logger.Error("Failed to list matches: %v", err)
+		return "", runtime.NewError("failed to list matches", 13)
+	}
+
+	response := ListMatchesResponse{
+		Matches: make([]MatchListing, 0, len(matches)),
+	}
+	for _, match := range matches {
+		if match.Label == nil {
+			continue
+		}
+
+		var label MatchLabel
+		if err := json.Unmarshal([]byte(match.Label.Value), &label); err != nil {
+			continue
+		}
+
+		response.Matches = append(response.Matches, MatchListing{
+			MatchID: match.MatchId,
+			Size:    match.Size,
+			Label:   label,
+		})
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// buildMatchQuery translates a listing request into a match label query
+func buildMatchQuery(request *ListMatchesRequest) (string, error) {
+	// Private matches are only reachable by invitation. Filtering them in the
+	// query keeps them from using up the page limit.
+	terms := []string{"+label.private:F"}
+
+	switch request.Filter {
+	case "", "open":
+		terms = append(terms, "+label.status:"+string(GameStatusWaiting), "+label.open_seats:>=1")
+	case "live":
+		terms = append(terms, "+label.status:"+string(GameStatusActive))
+	case "all":
+	default:
+		return "", runtime.NewError("invalid filter, must be 'open', 'live' or 'all'", 3)
+	}
+
+	if request.GameMode != "" {
+		if request.GameMode != "casual" && request.GameMode != "ranked" {
+			return "", runtime.NewError("invalid game_mode, must be 'casual' or 'ranked'", 3)
+		}
+		terms = append(terms, "+label.mode:"+request.GameMode)
+	}
+
+	if request.Variant != "" {
+		if !labelTermPattern.MatchString(request.Variant) {
+			return "", runtime.NewError("invalid variant", 3)
+		}
+		terms = append(terms, "+label.variant:"+request.Variant)
+	}
+
+	return strings.Join(terms, " "), nil
+}
@@ -5,24 +5,86 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/heroiclabs/nakama-common/rtapi"
 	"github.com/heroiclabs/nakama-common/runtime"
 )
 
+const (
+	GameModeCasual = "casual"
+	GameModeRanked = "ranked"
+
+	// NotificationCodeMatchFound tags notifications telling a queued player their match is ready
+	NotificationCodeMatchFound = 100
+	// NotificationCodeQueueExpired tells a player their queue entry was dropped for going stale
+	NotificationCodeQueueExpired = 105
+
+	// RankedRatingWindow is the rating gap a ranked player accepts on joining the queue
+	RankedRatingWindow = 200
+	// The window widens by RatingWindowGrowth every RatingWindowStep spent waiting, up to MaxRatingWindow
+	RatingWindowGrowth = 100
+	RatingWindowStep   = 10 * time.Second
+	MaxRatingWindow    = 800
+
+	// MaxPairingAttempts bounds how often FindMatch re-reads the queue after losing races for opponents
+	MaxPairingAttempts = 3
+)
+
+// MatchOptions selects the kind of game a match is created for
+type MatchOptions struct {
+	GameMode      string `json:"game_mode"`                // "casual" or "ranked"
+	Variant       string `json:"variant"`                  // Board variant name, see BoardVariants
+	SeriesLength  int    `json:"series_length,omitempty"`  // Games in a best-of-N set, 0 or 1 = single game
+	BotDifficulty string `json:"bot_difficulty,omitempty"` // Set when one player is the bot
+	BotBackfill   bool   `json:"bot_backfill,omitempty"`   // The bot stands in for a queued opponent
+}
+
+// MatchPlayer identifies a player paired by matchmaking
+type MatchPlayer struct {
+	UserID string `json:"user_id"`
+	Rating int    `json:"rating"`
+}
+
+// MatchAssignment describes an authoritative match created for two paired players
+type MatchAssignment struct {
+	MatchID      string `json:"match_id"`
+	PlayerX      string `json:"player_x"`
+	PlayerO      string `json:"player_o"`
+	GameMode     string `json:"game_mode"`
+	Variant      string `json:"variant"`
+	SeriesLength int    `json:"series_length"`
+}
+
 // MatchmakingQueue represents players waiting for a match
 type MatchmakingQueue struct {
 	UserID    string    `json:"user_id"`
 	GameMode  string    `json:"game_mode"`
+	Variant   string    `json:"variant,omitempty"`       // Empty means classic
+	Series    int       `json:"series_length,omitempty"` // Empty means single games
 	Rating    int       `json:"rating"`
 	Token     string    `json:"token"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// Last join_queue or queue_status call for the ticket; the entry expires a TTL after it
+	RefreshedAt time.Time `json:"refreshed_at,omitempty"`
+
+	// Opted in to playing a bot if nobody is found within the backfill wait
+	BotBackfill bool `json:"bot_backfill,omitempty"`
 }
 
 // JoinQueueRequest represents a request to join matchmaking
 type JoinQueueRequest struct {
-	GameMode string `json:"game_mode"` // "casual" or "ranked"
+	GameMode string `json:"game_mode"`               // "casual" or "ranked"
+	Variant  string `json:"variant,omitempty"`       // Board variant, defaults to classic
+	Series   int    `json:"series_length,omitempty"` // Best-of-N set length: 1 (default), 3, 5 or 7
+	Token    string `json:"token,omitempty"`         // Ticket from an earlier call; its outcome is returned instead of pairing again
+
+	// Play a bot matched to the player's rating if nobody is found in time
+	BotBackfill bool `json:"bot_backfill,omitempty"`
 }
 
 // JoinQueueResponse represents the response after joining queue
@@ -30,7 +92,41 @@ type JoinQueueResponse struct {
 	Token   string `json:"token"`
 	Message string `json:"message"`
 	MatchID string `json:"match_id,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
 	Matched bool   `json:"matched"`
+	Pending bool   `json:"pending,omitempty"` // An opponent is still creating the match for this ticket
+
+	// Rating gap currently accepted for ranked play; grows while waiting
+	RatingWindow int `json:"rating_window,omitempty"`
+}
+
+// QueueStatusRequest represents a request to check a matchmaking ticket
+type QueueStatusRequest struct {
+	Token string `json:"token"`
+}
+
+// QueueStatusResponse reports the state of a matchmaking ticket
+type QueueStatusResponse struct {
+	Token        string `json:"token"`
+	Status       string `json:"status"` // "waiting", "pending", "matched" or "not_found"
+	GameMode     string `json:"game_mode,omitempty"`
+	MatchID      string `json:"match_id,omitempty"`
+	Symbol       string `json:"symbol,omitempty"`
+	RatingWindow int    `json:"rating_window,omitempty"`
+}
+
+// QueueTicketResult records the match a queue ticket was paired into
+type QueueTicketResult struct {
+	Token     string    `json:"token"`
+	UserID    string    `json:"user_id"`
+	GameMode  string    `json:"game_mode"`
+	MatchID   string    `json:"match_id"`
+	Symbol    string    `json:"symbol"`
+	Opponent  string    `json:"opponent"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Set while the request that claimed the ticket is still creating the match
+	Pending bool `json:"pending,omitempty"`
 }
 
 // CancelQueueRequest represents a request to cancel matchmaking
@@ -52,10 +148,25 @@ func RpcJoinQueue(ctx context.Context, logger runtime.Logger, db *sql.DB, nk run
 	}
 
 	// Validate game mode
-	if request.GameMode != "casual" && request.GameMode != "ranked" {
+	if request.GameMode != GameModeCasual && request.GameMode != GameModeRanked {
 		return "", runtime.NewError("invalid game_mode, must be 'casual' or 'ranked'", 3)
 	}
 
+	// Validate board variant
+	if request.Variant == "" {
+		request.Variant = VariantClassic
+	}
+	if _, err := LookupVariant(request.Variant, 0); err != nil {
+		return "", runtime.NewError("invalid variant", 3)
+	}
+
+	if request.Series == 0 {
+		request.Series = 1
+	}
+	if !ValidSeriesLength(request.Series) {
+		return "", runtime.NewError("invalid series_length, must be 1, 3, 5 or 7", 3)
+	}
+
 	// Get user profile for rating
 	profile, err := GetUserProfile(ctx, logger, nk, userID)
 	if err != nil {
@@ -63,34 +174,93 @@ func RpcJoinQueue(ctx context.Context, logger runtime.Logger, db *sql.DB, nk run
 		return "", runtime.NewError("failed to get user profile", 13)
 	}
 
+	// A client re-joining with its previous ticket gets that ticket's outcome,
+	// so a player who was just paired is never paired a second time
+	if request.Token != "" {
+		result, err := GetQueueTicketResult(ctx, nk, userID, request.Token)
+		if err != nil {
+			logger.Error("Failed to read queue ticket result: %v", err)
+			return "", runtime.NewError("matchmaking failed", 13)
+		}
+		if result != nil {
+			return ticketResultResponse(result)
+		}
+	}
+
 	// Generate matchmaking token
 	token := uuid.New().String()
+	joinedAt := time.Now()
+
+	// Take our own entry out of the queue while we look for an opponent, so no
+	// concurrent join can pair with us at the same time
+	existing, version, err := GetQueueEntry(ctx, nk, userID)
+	if err != nil {
+		logger.Error("Failed to read existing queue entry: %v", err)
+		return "", runtime.NewError("matchmaking failed", 13)
+	}
+	if existing != nil {
+		claimed, err := ClaimQueueEntry(ctx, nk, userID, version)
+		if err != nil {
+			logger.Error("Failed to claim own queue entry: %v", err)
+			return "", runtime.NewError("matchmaking failed", 13)
+		}
+
+		if !claimed {
+			// Another player paired with us while this request was in flight
+			result, err := GetQueueTicketResult(ctx, nk, userID, existing.Token)
+			if err != nil {
+				logger.Error("Failed to read queue ticket result: %v", err)
+				return "", runtime.NewError("matchmaking failed", 13)
+			}
+			if result != nil {
+				return ticketResultResponse(result)
+			}
+		} else if existing.GameMode == request.GameMode && queueVariant(existing) == request.Variant && queueSeries(existing) == request.Series {
+			// Re-joining the same queue keeps the player's place, so their rating window keeps widening
+			token = existing.Token
+			joinedAt = existing.Timestamp
+		}
+	}
 
 	// Create queue entry
 	queueEntry := MatchmakingQueue{
 		UserID:    userID,
 		GameMode:  request.GameMode,
+		Variant:   request.Variant,
+		Series:    request.Series,
 		Rating:    profile.Rating,
 		Token:     token,
-		Timestamp: time.Now(),
+		Timestamp: joinedAt,
+
+		RefreshedAt: time.Now(),
+
+		BotBackfill: request.BotBackfill,
+	}
+
+	ratingWindow := 0
+	if request.GameMode == GameModeRanked {
+		ratingWindow = RatingWindow(time.Since(joinedAt))
 	}
 
 	// Try to find a match
-	matchID, opponent, err := FindMatch(ctx, logger, nk, &queueEntry)
+	assignment, opponent, err := FindMatch(ctx, logger, nk, &queueEntry)
 	if err != nil {
 		logger.Error("Error finding match: %v", err)
 		return "", runtime.NewError("matchmaking failed", 13)
 	}
 
-	if matchID != "" {
+	if assignment != nil {
 		// Match found!
-		logger.Info("Match found - Match ID: %s, Players: %s vs %s", matchID, userID, opponent.UserID)
+		logger.Info("Match found - Match ID: %s, Players: %s vs %s", assignment.MatchID, userID, opponent.UserID)
 
 		response := JoinQueueResponse{
 			Token:   token,
 			Message: "match found",
-			MatchID: matchID,
+			MatchID: assignment.MatchID,
+			Symbol:  string(assignment.SymbolFor(userID)),
 			Matched: true,
+
+			RatingWindow: ratingWindow,
 		}
 
 		responseJSON, _ := json.Marshal(response)
@@ -103,12 +273,112 @@ func RpcJoinQueue(ctx context.Context, logger runtime.Logger, db *sql.DB, nk run
 		return "", runtime.NewError("failed to join queue", 13)
 	}
 
-	logger.Info("Player added to queue - UserID: %s, Mode: %s, Token: %s", userID, request.GameMode, token)
+	logger.Info("Player added to queue - UserID: %s, Mode: %s, Token: %s, Rating window: %d", userID, request.GameMode, token, ratingWindow)
 
 	response := JoinQueueResponse{
 		Token:   token,
 		Message: "waiting for opponent",
 		Matched: false,
+
+		RatingWindow: ratingWindow,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// ticketResultResponse reports an already paired ticket back to join_queue
+func ticketResultResponse(result *QueueTicketResult) (string, error) {
+	response := JoinQueueResponse{
+		Token:   result.Token,
+		Message: "match found",
+		MatchID: result.MatchID,
+		Symbol:  result.Symbol,
+		Matched: true,
+	}
+	if result.Pending {
+		response.Message = "pairing in progress"
+		response.Matched = false
+		response.Pending = true
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return "", runtime.NewError("failed to create response", 13)
+	}
+	return string(responseJSON), nil
+}
+
+// RpcQueueStatus lets a client poll its matchmaking ticket, e.g. after missing the match-found notification
+func RpcQueueStatus(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	var request QueueStatusRequest
+	if err := json.Unmarshal([]byte(payload), &request); err != nil {
+		logger.Error("Failed to unmarshal request: %v", err)
+		return "", runtime.NewError("invalid request payload", 3)
+	}
+
+	if request.Token == "" {
+		return "", runtime.NewError("token is required", 3)
+	}
+
+	response := QueueStatusResponse{
+		Token:  request.Token,
+		Status: "not_found",
+	}
+
+	entry, version, err := GetQueueEntry(ctx, nk, userID)
+	if err != nil {
+		logger.Error("Failed to read queue entry: %v", err)
+		return "", runtime.NewError("failed to read queue status", 13)
+	}
+
+	// Players who opted in get their bot as soon as the wait is over, without waiting for the sweeper
+	if entry != nil && entry.Token == request.Token && entry.BotBackfill &&
+		time.Since(entry.Timestamp) >= LoadMaintenanceConfig(ctx, logger).BotBackfillWait {
+		if _, err := BackfillWithBot(ctx, logger, nk, entry, version); err != nil {
+			logger.Error("Failed to backfill with bot: %v", err)
+		} else {
+			// Either the bot match or a human opponent now holds the ticket's result
+			entry = nil
+		}
+	}
+
+	if entry != nil && entry.Token == request.Token {
+		// Polling keeps the ticket from expiring; losing the write to a pairing is fine
+		entry.RefreshedAt = time.Now()
+		if err := saveQueueEntry(ctx, nk, entry, version); err != nil {
+			logger.Debug("Queue entry for %s changed while refreshing: %v", userID, err)
+		}
+
+		response.Status = "waiting"
+		response.GameMode = entry.GameMode
+		if entry.GameMode == GameModeRanked {
+			response.RatingWindow = RatingWindow(time.Since(entry.Timestamp))
+		}
+	} else {
+		result, err := GetQueueTicketResult(ctx, nk, userID, request.Token)
+		if err != nil {
+			logger.Error("Failed to read queue ticket result: %v", err)
+			return "", runtime.NewError("failed to read queue status", 13)
+		}
+		if result != nil && result.Pending {
+			response.Status = "pending"
+		} else if result != nil {
+			response.Status = "matched"
+			response.GameMode = result.GameMode
+			response.MatchID = result.MatchID
+			response.Symbol = result.Symbol
+		}
 	}
 
 	responseJSON, err := json.Marshal(response)
@@ -148,81 +418,638 @@ func RpcCancelQueue(ctx context.Context, logger runtime.Logger, db *sql.DB, nk r
 	return `{"success": true, "message": "removed from queue"}`, nil
 }
 
-// FindMatch attempts to find a suitable opponent in the queue
-func FindMatch(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, player *MatchmakingQueue) (string, *MatchmakingQueue, error) {
-	// List all queue entries for the same game mode
-	objects, _, err := nk.StorageList(ctx, "", "", "matchmaking_queue", 100, "")
+// FindMatch attempts to find the most suitable opponent in the queue.
+// Ranked players are paired with the closest rating inside the wider of the two
+// players' rating windows; casual players with whoever has waited longest.
+//
+// An opponent is claimed by deleting their entry at the version it was read, so
+// concurrent joins can never both pair with the same waiting player. Losing a
+// claim moves on to the next candidate, re-reading the queue if needed.
+func FindMatch(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, player *MatchmakingQueue) (*MatchAssignment, *MatchmakingQueue, error) {
+	for attempt := 0; attempt < MaxPairingAttempts; attempt++ {
+		candidates, err := listQueueCandidates(ctx, nk, player)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(candidates) == 0 {
+			// No match found
+			return nil, nil, nil
+		}
+
+		for _, candidate := range candidates {
+			opponent := &candidate.Entry
+			claimed, err := ClaimQueueTicket(ctx, nk, opponent, candidate.Version)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !claimed {
+				logger.Debug("Queue entry %s was claimed by another request", candidate.Key)
+				continue
+			}
+
+			assignment, err := startQueuedMatch(ctx, logger, nk, player, opponent)
+			if err != nil {
+				// Put the opponent back so they don't silently lose their place
+				if restoreErr := ReleaseQueueTicket(ctx, nk, opponent); restoreErr != nil {
+					logger.Error("Failed to restore opponent to queue: %v", restoreErr)
+				}
+				return nil, nil, err
+			}
+
+			return assignment, opponent, nil
+		}
+	}
+
+	logger.Warn("Gave up pairing %s after %d contended attempts", player.UserID, MaxPairingAttempts)
+	return nil, nil, nil
+}
+
+// queueCandidate is a queue entry together with the storage version it was read at
+type queueCandidate struct {
+	Entry      MatchmakingQueue
+	Key        string
+	Version    string
+	RatingDiff int
+}
+
+// listQueueCandidates returns the acceptable opponents for a player, best first
+func listQueueCandidates(ctx context.Context, nk runtime.NakamaModule, player *MatchmakingQueue) ([]*queueCandidate, error) {
+	now := time.Now()
+	candidates := make([]*queueCandidate, 0)
+
+	cursor := ""
+	for {
+		objects, nextCursor, err := nk.StorageList(ctx, "", "", "matchmaking_queue", 100, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range objects {
+			var opponent MatchmakingQueue
+			if err := json.Unmarshal([]byte(obj.Value), &opponent); err != nil {
+				continue
+			}
+
+			// Skip if same user, different game mode or different board
+			if opponent.UserID == player.UserID || opponent.GameMode != player.GameMode {
+				continue
+			}
+			if queueVariant(&opponent) != queueVariant(player) || queueSeries(&opponent) != queueSeries(player) {
+				continue
+			}
+
+			ratingDiff := abs(player.Rating - opponent.Rating)
+
+			// For ranked, the gap must fit inside the wider of the two rating windows
+			if player.GameMode == GameModeRanked {
+				window := max(RatingWindow(now.Sub(player.Timestamp)), RatingWindow(now.Sub(opponent.Timestamp)))
+				if ratingDiff > window {
+					continue
+				}
+			}
+
+			candidates = append(candidates, &queueCandidate{
+				Entry:      opponent,
+				Key:        obj.Key,
+				Version:    obj.Version,
+				RatingDiff: ratingDiff,
+			})
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if player.GameMode == GameModeRanked && a.RatingDiff != b.RatingDiff {
+			return a.RatingDiff < b.RatingDiff
+		}
+		return a.Entry.Timestamp.Before(b.Entry.Timestamp)
+	})
+
+	return candidates, nil
+}
+
+// startQueuedMatch creates the match for a claimed pairing and lets both tickets know about it
+func startQueuedMatch(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, player, opponent *MatchmakingQueue) (*MatchAssignment, error) {
+	// Start the authoritative match
+	assignment, err := CreateGameMatch(ctx, logger, nk,
+		MatchPlayer{UserID: player.UserID, Rating: player.Rating},
+		MatchPlayer{UserID: opponent.UserID, Rating: opponent.Rating},
+		MatchOptions{GameMode: player.GameMode, Variant: queueVariant(player), SeriesLength: queueSeries(player)})
 	if err != nil {
-		return "", nil, err
+		return nil, err
+	}
+
+	// Record both tickets so either player can look the match up later
+	for _, ticket := range []*MatchmakingQueue{player, opponent} {
+		if err := SaveQueueTicketResult(ctx, nk, ticket, assignment); err != nil {
+			logger.Error("Failed to record ticket result for %s: %v", ticket.UserID, err)
+		}
 	}
 
-	var bestMatch *MatchmakingQueue
-	var bestMatchKey string
+	// The waiting player isn't part of this request, so tell them directly
+	NotifyMatchFound(ctx, logger, nk, opponent, assignment)
+
+	return assignment, nil
+}
 
-	for _, obj := range objects {
-		var opponent MatchmakingQueue
-		if err := json.Unmarshal([]byte(obj.Value), &opponent); err != nil {
-			continue
+// BackfillWithBot pairs a queued player with a bot playing at their rating.
+// The entry is claimed first, so a human opponent who takes it wins the race
+// and nil is returned.
+func BackfillWithBot(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, entry *MatchmakingQueue, version string) (*MatchAssignment, error) {
+	claimed, err := ClaimQueueTicket(ctx, nk, entry, version)
+	if err != nil || !claimed {
+		return nil, err
+	}
+
+	assignment, err := CreateGameMatch(ctx, logger, nk,
+		MatchPlayer{UserID: entry.UserID, Rating: entry.Rating},
+		MatchPlayer{UserID: BotUserID, Rating: entry.Rating},
+		MatchOptions{
+			GameMode:      entry.GameMode,
+			Variant:       queueVariant(entry),
+			SeriesLength:  queueSeries(entry),
+			BotDifficulty: BotDifficultyMatched,
+			BotBackfill:   true,
+		})
+	if err != nil {
+		if restoreErr := ReleaseQueueTicket(ctx, nk, entry); restoreErr != nil {
+			logger.Error("Failed to restore player to queue: %v", restoreErr)
 		}
+		return nil, err
+	}
+
+	if err := SaveQueueTicketResult(ctx, nk, entry, assignment); err != nil {
+		logger.Error("Failed to record ticket result for %s: %v", entry.UserID, err)
+	}
+	NotifyMatchFound(ctx, logger, nk, entry, assignment)
+
+	logger.Info("Backfilled queued player with bot - UserID: %s, Match: %s, Rating: %d", entry.UserID, assignment.MatchID, entry.Rating)
+	return assignment, nil
+}
 
-		// Skip if same user or different game mode
-		if opponent.UserID == player.UserID || opponent.GameMode != player.GameMode {
-			continue
+// BackfillQueuedPlayers pairs every opted-in player who has waited at least wait with a bot
+func BackfillQueuedPlayers(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, wait time.Duration) (int, error) {
+	cutoff := time.Now().Add(-wait)
+	backfilled := 0
+
+	cursor := ""
+	for {
+		objects, nextCursor, err := nk.StorageList(ctx, "", "", "matchmaking_queue", 100, cursor)
+		if err != nil {
+			return backfilled, err
 		}
 
-		// For ranked, check rating difference (within 200 rating)
-		if player.GameMode == "ranked" {
-			ratingDiff := abs(player.Rating - opponent.Rating)
-			if ratingDiff > 200 {
+		for _, obj := range objects {
+			var entry MatchmakingQueue
+			if err := json.Unmarshal([]byte(obj.Value), &entry); err != nil {
 				continue
 			}
+			if !entry.BotBackfill || entry.Timestamp.After(cutoff) {
+				continue
+			}
+
+			assignment, err := BackfillWithBot(ctx, logger, nk, &entry, obj.Version)
+			if err != nil {
+				logger.Error("Failed to backfill %s with bot: %v", entry.UserID, err)
+				continue
+			}
+			if assignment != nil {
+				backfilled++
+			}
 		}
 
-		// Found a suitable match
-		bestMatch = &opponent
-		bestMatchKey = obj.Key
-		break
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
 	}
 
-	if bestMatch == nil {
-		// No match found
-		return "", nil, nil
+	return backfilled, nil
+}
+
+// ClaimQueueEntry removes a queue entry only if it is unchanged since it was read.
+// It reports false when another request claimed or replaced the entry first.
+func ClaimQueueEntry(ctx context.Context, nk runtime.NakamaModule, userID, version string) (bool, error) {
+	err := nk.StorageDelete(ctx, []*runtime.StorageDelete{
+		{
+			Collection: "matchmaking_queue",
+			Key:        userID,
+			UserID:     "",
+			Version:    version,
+		},
+	})
+	if err == nil {
+		return true, nil
 	}
 
-	// Create a new match
-	matchID := uuid.New().String()
+	// A rejected version check means someone else got there first
+	current, currentVersion, readErr := GetQueueEntry(ctx, nk, userID)
+	if readErr != nil {
+		return false, readErr
+	}
+	if current == nil || currentVersion != version {
+		return false, nil
+	}
+	return false, err
+}
 
-	// Randomly assign X and O
-	playerX := player.UserID
-	playerO := bestMatch.UserID
-	if time.Now().UnixNano()%2 == 0 {
-		playerX, playerO = playerO, playerX
+// ClaimQueueTicket takes a waiting player's entry for pairing. The entry is deleted
+// at the version it was read and a pending result is recorded for its ticket in
+// one update, so the player re-joining meanwhile sees the pairing in progress.
+func ClaimQueueTicket(ctx context.Context, nk runtime.NakamaModule, entry *MatchmakingQueue, version string) (bool, error) {
+	pending, err := queueTicketResultWrite(&QueueTicketResult{
+		Token:     entry.Token,
+		UserID:    entry.UserID,
+		GameMode:  entry.GameMode,
+		Timestamp: time.Now(),
+		Pending:   true,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	_, _, err = nk.MultiUpdate(ctx, nil, []*runtime.StorageWrite{pending}, []*runtime.StorageDelete{
+		{
+			Collection: "matchmaking_queue",
+			Key:        entry.UserID,
+			UserID:     "",
+			Version:    version,
+		},
+	}, nil, false)
+	if err == nil {
+		return true, nil
 	}
 
-	// Create game state
-	gameState := NewGameState(matchID, playerX, playerO, player.GameMode)
-	if err := SaveGameState(ctx, nk, gameState); err != nil {
-		return "", nil, err
+	// A rejected version check means someone else got there first
+	current, currentVersion, readErr := GetQueueEntry(ctx, nk, entry.UserID)
+	if readErr != nil {
+		return false, readErr
+	}
+	if current == nil || currentVersion != version {
+		return false, nil
 	}
+	return false, err
+}
 
-	// Remove opponent from queue
-	if err := nk.StorageDelete(ctx, []*runtime.StorageDelete{
+// ReleaseQueueTicket undoes ClaimQueueTicket after pairing failed, putting the player back in the queue
+func ReleaseQueueTicket(ctx context.Context, nk runtime.NakamaModule, entry *MatchmakingQueue) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = nk.MultiUpdate(ctx, nil, []*runtime.StorageWrite{
+		{
+			Collection:      "matchmaking_queue",
+			Key:             entry.UserID,
+			UserID:          "",
+			Value:           string(data),
+			PermissionRead:  0,
+			PermissionWrite: 0,
+		},
+	}, []*runtime.StorageDelete{
+		{
+			Collection: "matchmaking_results",
+			Key:        entry.Token,
+			UserID:     entry.UserID,
+		},
+	}, nil, false)
+	return err
+}
+
+// NotifyMatchFound sends a queued player a notification carrying their match and symbol
+func NotifyMatchFound(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, ticket *MatchmakingQueue, assignment *MatchAssignment) {
+	content := map[string]interface{}{
+		"token":         ticket.Token,
+		"match_id":      assignment.MatchID,
+		"symbol":        string(assignment.SymbolFor(ticket.UserID)),
+		"game_mode":     assignment.GameMode,
+		"series_length": assignment.SeriesLength,
+		"bot":           IsBotUser(assignment.OpponentOf(ticket.UserID)),
+	}
+
+	// Persistent so a player who is briefly offline still receives it
+	if err := nk.NotificationSend(ctx, ticket.UserID, "Match found", content, NotificationCodeMatchFound, "", true); err != nil {
+		logger.Error("Failed to notify player %s of match: %v", ticket.UserID, err)
+	}
+}
+
+// SaveQueueTicketResult stores the match a ticket was paired into, readable by its owner
+func SaveQueueTicketResult(ctx context.Context, nk runtime.NakamaModule, ticket *MatchmakingQueue, assignment *MatchAssignment) error {
+	write, err := queueTicketResultWrite(&QueueTicketResult{
+		Token:     ticket.Token,
+		UserID:    ticket.UserID,
+		GameMode:  assignment.GameMode,
+		MatchID:   assignment.MatchID,
+		Symbol:    string(assignment.SymbolFor(ticket.UserID)),
+		Opponent:  assignment.OpponentOf(ticket.UserID),
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{write})
+	return err
+}
+
+// queueTicketResultWrite builds the storage write recording a ticket result under its owner
+func queueTicketResultWrite(result *QueueTicketResult) (*runtime.StorageWrite, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &runtime.StorageWrite{
+		Collection:      "matchmaking_results",
+		Key:             result.Token,
+		UserID:          result.UserID,
+		Value:           string(data),
+		PermissionRead:  1, // Owner read
+		PermissionWrite: 0,
+	}, nil
+}
+
+// GetQueueTicketResult returns the recorded match for a ticket, or nil if it was never paired
+func GetQueueTicketResult(ctx context.Context, nk runtime.NakamaModule, userID, token string) (*QueueTicketResult, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{
+		{
+			Collection: "matchmaking_results",
+			Key:        token,
+			UserID:     userID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	var result QueueTicketResult
+	if err := json.Unmarshal([]byte(objects[0].Value), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SymbolFor returns the symbol assigned to the given player
+func (a *MatchAssignment) SymbolFor(userID string) PlayerSymbol {
+	switch userID {
+	case a.PlayerX:
+		return SymbolX
+	case a.PlayerO:
+		return SymbolO
+	}
+	return SymbolEmpty
+}
+
+// OpponentOf returns the other player in the match
+func (a *MatchAssignment) OpponentOf(userID string) string {
+	if userID == a.PlayerX {
+		return a.PlayerO
+	}
+	return a.PlayerX
+}
+
+// CreateGameMatch starts an authoritative tictactoe match for two paired players.
+// Both the storage queue and Nakama's matchmaker create their matches here.
+func CreateGameMatch(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, playerA, playerB MatchPlayer, options MatchOptions) (*MatchAssignment, error) {
+	// Bot games are never rated
+	gameMode := options.GameMode
+	if gameMode != GameModeRanked || options.BotDifficulty != "" {
+		gameMode = GameModeCasual
+	}
+
+	variant, err := LookupVariant(options.Variant, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	x, o, firstMove := AssignSymbols(ctx, logger, nk, playerA, playerB)
+	playerX, playerO := x.UserID, o.UserID
+
+	params := map[string]interface{}{
+		"player1":    playerX,
+		"player2":    playerO,
+		"rating1":    x.Rating,
+		"rating2":    o.Rating,
+		"game_mode":  gameMode,
+		"variant":    variant.Name,
+		"first_move": firstMove,
+	}
+
+	if options.SeriesLength > 1 {
+		params["series_length"] = options.SeriesLength
+	}
+
+	if options.BotDifficulty != "" {
+		params["bot_difficulty"] = options.BotDifficulty
+		params["bot_backfill"] = options.BotBackfill
+	}
+
+	matchID, err := nk.MatchCreate(ctx, "tictactoe", params)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Created match - ID: %s, Mode: %s, Variant: %s, X: %s, O: %s, First move: %s", matchID, gameMode, variant.Name, playerX, playerO, firstMove)
+
+	return &MatchAssignment{
+		MatchID:      matchID,
+		PlayerX:      playerX,
+		PlayerO:      playerO,
+		GameMode:     gameMode,
+		Variant:      variant.Name,
+		SeriesLength: max(options.SeriesLength, 1),
+	}, nil
+}
+
+// MatchmakerMatched creates an authoritative match for players paired by Nakama's built-in matchmaker
+func MatchmakerMatched(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, entries []runtime.MatchmakerEntry) (string, error) {
+	logger.Info("Matchmaker matched %d players", len(entries))
+
+	if len(entries) != 2 {
+		logger.Error("Invalid number of players matched: %d, expected 2", len(entries))
+		return "", nil
+	}
+
+	players := make([]MatchPlayer, 0, 2)
+	gameMode := ""
+	variant := ""
+	series := ""
+	for _, entry := range entries {
+		// The rating property is stamped server-side by BeforeMatchmakerAdd
+		rating, _ := entry.GetProperties()["rating"].(float64)
+		players = append(players, MatchPlayer{
+			UserID: entry.GetPresence().GetUserId(),
+			Rating: int(rating),
+		})
+		logger.Info("Player matched - UserID: %s, Username: %s",
+			entry.GetPresence().GetUserId(),
+			entry.GetPresence().GetUsername())
+
+		// Tickets are scoped to one mode by BeforeMatchmakerAdd; fall back to casual on any mismatch
+		mode, _ := entry.GetProperties()["game_mode"].(string)
+		if gameMode == "" {
+			gameMode = mode
+		} else if gameMode != mode {
+			logger.Warn("Matched tickets disagree on game mode (%s vs %s), using casual", gameMode, mode)
+			gameMode = GameModeCasual
+		}
+
+		board, _ := entry.GetProperties()["variant"].(string)
+		if variant == "" {
+			variant = board
+		} else if variant != board {
+			logger.Warn("Matched tickets disagree on variant (%s vs %s), using classic", variant, board)
+			variant = VariantClassic
+		}
+
+		length, _ := entry.GetProperties()["series_length"].(string)
+		if series == "" {
+			series = length
+		} else if series != length {
+			logger.Warn("Matched tickets disagree on series length (%s vs %s), using single games", series, length)
+			series = "1"
+		}
+	}
+	seriesLength, _ := strconv.Atoi(series)
+
+	// Prevent self-matching
+	if players[0].UserID == players[1].UserID {
+		logger.Info("Prevented self-match for UserID: %s", players[0].UserID)
+		return "", nil
+	}
+
+	assignment, err := CreateGameMatch(ctx, logger, nk, players[0], players[1], MatchOptions{GameMode: gameMode, Variant: variant, SeriesLength: seriesLength})
+	if err != nil {
+		logger.Error("Failed to create match: %v", err)
+		return "", err
+	}
+
+	return assignment.MatchID, nil
+}
+
+// BeforeMatchmakerAdd stamps a matchmaker ticket with the player's game mode and
+// server-side rating, and rewrites its query so the built-in matchmaker applies
+// the same pairing rules as the storage queue
+func BeforeMatchmakerAdd(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, in *rtapi.Envelope) (*rtapi.Envelope, error) {
+	request := in.GetMatchmakerAdd()
+	if request == nil {
+		return in, nil
+	}
+
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return nil, runtime.NewError("user not authenticated", 16)
+	}
+
+	gameMode := request.StringProperties["game_mode"]
+	if gameMode == "" {
+		gameMode = GameModeCasual
+	}
+	if gameMode != GameModeCasual && gameMode != GameModeRanked {
+		return nil, runtime.NewError("invalid game_mode, must be 'casual' or 'ranked'", 3)
+	}
+
+	variant := request.StringProperties["variant"]
+	if variant == "" {
+		variant = VariantClassic
+	}
+	if _, err := LookupVariant(variant, 0); err != nil {
+		return nil, runtime.NewError("invalid variant", 3)
+	}
+
+	series := request.StringProperties["series_length"]
+	if series == "" {
+		series = "1"
+	}
+	if length, err := strconv.Atoi(series); err != nil || !ValidSeriesLength(length) {
+		return nil, runtime.NewError("invalid series_length, must be 1, 3, 5 or 7", 3)
+	}
+
+	profile, err := GetUserProfile(ctx, logger, nk, userID)
+	if err != nil {
+		logger.Error("Failed to get user profile: %v", err)
+		return nil, runtime.NewError("failed to get user profile", 13)
+	}
+
+	if request.StringProperties == nil {
+		request.StringProperties = make(map[string]string)
+	}
+	if request.NumericProperties == nil {
+		request.NumericProperties = make(map[string]float64)
+	}
+	request.StringProperties["game_mode"] = gameMode
+	request.StringProperties["variant"] = variant
+	request.StringProperties["series_length"] = series
+	request.NumericProperties["rating"] = float64(profile.Rating)
+
+	// Games are strictly one-on-one
+	request.MinCount = 2
+	request.MaxCount = 2
+	request.CountMultiple = nil
+
+	request.Query = fmt.Sprintf("+properties.game_mode:%s +properties.variant:%s +properties.series_length:%s", gameMode, variant, series)
+	if gameMode == GameModeRanked {
+		request.Query += fmt.Sprintf(" +properties.rating:>=%d +properties.rating:<=%d",
+			profile.Rating-RankedRatingWindow, profile.Rating+RankedRatingWindow)
+	}
+
+	logger.Info("Matchmaker ticket - UserID: %s, Mode: %s, Rating: %d", userID, gameMode, profile.Rating)
+	return in, nil
+}
+
+// RatingWindow returns the rating gap a ranked player accepts after waiting for the given time
+func RatingWindow(waited time.Duration) int {
+	if waited < 0 {
+		waited = 0
+	}
+	steps := int(waited / RatingWindowStep)
+	return min(RankedRatingWindow+steps*RatingWindowGrowth, MaxRatingWindow)
+}
+
+// GetQueueEntry returns the player's current queue entry and its storage version,
+// or nil if they are not queued
+func GetQueueEntry(ctx context.Context, nk runtime.NakamaModule, userID string) (*MatchmakingQueue, string, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{
 		{
 			Collection: "matchmaking_queue",
-			Key:        bestMatchKey,
+			Key:        userID,
 			UserID:     "",
 		},
-	}); err != nil {
-		logger.Error("Failed to remove opponent from queue: %v", err)
+	})
+	if err != nil {
+		return nil, "", err
 	}
 
-	logger.Info("Created match - ID: %s, X: %s, O: %s", matchID, playerX, playerO)
+	if len(objects) == 0 {
+		return nil, "", nil
+	}
 
-	return matchID, bestMatch, nil
+	var entry MatchmakingQueue
+	if err := json.Unmarshal([]byte(objects[0].Value), &entry); err != nil {
+		return nil, "", err
+	}
+	return &entry, objects[0].Version, nil
 }
 
 // AddToQueue adds a player to the matchmaking queue
 func AddToQueue(ctx context.Context, nk runtime.NakamaModule, entry *MatchmakingQueue) error {
+	return saveQueueEntry(ctx, nk, entry, "")
+}
+
+// saveQueueEntry writes a queue entry only if the stored copy is still at the
+// given version. An empty version writes unconditionally.
+func saveQueueEntry(ctx context.Context, nk runtime.NakamaModule, entry *MatchmakingQueue, version string) error {
 	data, err := json.Marshal(entry)
 	if err != nil {
 		return err
@@ -234,6 +1061,7 @@ func AddToQueue(ctx context.Context, nk runtime.NakamaModule, entry *Matchmaking
 			Key:             entry.UserID,
 			UserID:          "",
 			Value:           string(data),
+			Version:         version,
 			PermissionRead:  0,
 			PermissionWrite: 0,
 		},
@@ -263,6 +1091,27 @@ func RemoveFromQueue(ctx context.Context, nk runtime.NakamaModule, userID string
 	return nil
 }
 
+// queueSeries returns the set length of a queue entry, treating entries from before series existed as single games
+func queueSeries(entry *MatchmakingQueue) int {
+	return max(entry.Series, 1)
+}
+
+// queueVariant returns the board variant of a queue entry, treating entries from before variants existed as classic
+func queueVariant(entry *MatchmakingQueue) string {
+	if entry.Variant == "" {
+		return VariantClassic
+	}
+	return entry.Variant
+}
+
+// LastSeen returns when the player last asked about their ticket
+func (q *MatchmakingQueue) LastSeen() time.Time {
+	if q.RefreshedAt.IsZero() {
+		return q.Timestamp
+	}
+	return q.RefreshedAt
+}
+
 // abs returns the absolute value of an integer
 func abs(x int) int {
 	if x < 0 {
@@ -271,37 +1120,107 @@ func abs(x int) int {
 	return x
 }
 
-// CleanupExpiredQueueEntries removes queue entries older than 60 seconds
-func CleanupExpiredQueueEntries(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule) error {
-	objects, _, err := nk.StorageList(ctx, "", "", "matchmaking_queue", 100, "")
-	if err != nil {
-		return err
+// CleanupExpiredQueueEntries removes queue entries not refreshed within ttl, tells their players
+// and returns how many were removed. Entries are claimed one at a time, so an entry that is
+// paired or refreshed mid-sweep is left alone.
+func CleanupExpiredQueueEntries(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, ttl time.Duration) (int, error) {
+	cutoff := time.Now().Add(-ttl)
+	removed := 0
+
+	cursor := ""
+	for {
+		objects, nextCursor, err := nk.StorageList(ctx, "", "", "matchmaking_queue", 100, cursor)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, obj := range objects {
+			var entry MatchmakingQueue
+			if err := json.Unmarshal([]byte(obj.Value), &entry); err != nil {
+				continue
+			}
+
+			if !entry.LastSeen().Before(cutoff) {
+				continue
+			}
+
+			claimed, err := ClaimQueueEntry(ctx, nk, obj.Key, obj.Version)
+			if err != nil {
+				return removed, fmt.Errorf("failed to cleanup expired entries: %w", err)
+			}
+			if !claimed {
+				continue
+			}
+			removed++
+
+			content := map[string]interface{}{
+				"token":     entry.Token,
+				"game_mode": entry.GameMode,
+			}
+			if err := nk.NotificationSend(ctx, entry.UserID, "Queue expired", content, NotificationCodeQueueExpired, "", true); err != nil {
+				logger.Error("Failed to notify player %s of expired queue entry: %v", entry.UserID, err)
+			}
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
 	}
 
-	cutoff := time.Now().Add(-60 * time.Second)
-	var deletes []*runtime.StorageDelete
+	if removed > 0 {
+		logger.Info("Cleaned up %d expired queue entries", removed)
+	}
+
+	return removed, nil
+}
 
-	for _, obj := range objects {
-		var entry MatchmakingQueue
-		if err := json.Unmarshal([]byte(obj.Value), &entry); err != nil {
-			continue
+// CleanupExpiredQueueResults deletes ticket results recorded more than ttl ago, including
+// pending results left behind by a pairing that never finished
+func CleanupExpiredQueueResults(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, ttl time.Duration) (int, error) {
+	cutoff := time.Now().Add(-ttl)
+	removed := 0
+
+	cursor := ""
+	for {
+		objects, nextCursor, err := nk.StorageList(ctx, "", "", "matchmaking_results", 100, cursor)
+		if err != nil {
+			return removed, err
 		}
 
-		if entry.Timestamp.Before(cutoff) {
-			deletes = append(deletes, &runtime.StorageDelete{
-				Collection: "matchmaking_queue",
-				Key:        obj.Key,
-				UserID:     "",
+		for _, obj := range objects {
+			var result QueueTicketResult
+			if err := json.Unmarshal([]byte(obj.Value), &result); err != nil {
+				continue
+			}
+			if !result.Timestamp.Before(cutoff) {
+				continue
+			}
+
+			// Skip results rewritten since they were listed
+			err := nk.StorageDelete(ctx, []*runtime.StorageDelete{
+				{
+					Collection: "matchmaking_results",
+					Key:        obj.Key,
+					UserID:     obj.UserId,
+					Version:    obj.Version,
+				},
 			})
+			if err != nil {
+				continue
+			}
+			removed++
 		}
-	}
 
-	if len(deletes) > 0 {
-		if err := nk.StorageDelete(ctx, deletes); err != nil {
-			return fmt.Errorf("failed to cleanup expired entries: %w", err)
+		if nextCursor == "" {
+			break
 		}
-		logger.Info("Cleaned up %d expired queue entries", len(deletes))
+		cursor = nextCursor
 	}
 
-	return nil
+	if removed > 0 {
+		logger.Info("Cleaned up %d expired queue ticket results", removed)
+	}
+
+	return removed, nil
 }
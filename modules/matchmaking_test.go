@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// fakeNakama is an in-memory NakamaModule covering the storage, match and notification calls matchmaking makes
+type fakeNakama struct {
+	runtime.NakamaModule
+
+	mu       sync.Mutex
+	objects  map[string]*api.StorageObject
+	versions int
+	matches  []string
+
+	// Called inside MatchCreate before the match ID is returned, to hold a pairing open
+	onMatchCreate func()
+}
+
+var errVersionConflict = errors.New("storage version check failed")
+
+func newFakeNakama() *fakeNakama {
+	return &fakeNakama{objects: make(map[string]*api.StorageObject)}
+}
+
+func storageKey(collection, userID, key string) string {
+	return collection + "/" + userID + "/" + key
+}
+
+func (f *fakeNakama) StorageRead(ctx context.Context, reads []*runtime.StorageRead) ([]*api.StorageObject, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects := make([]*api.StorageObject, 0, len(reads))
+	for _, read := range reads {
+		if obj, ok := f.objects[storageKey(read.Collection, read.UserID, read.Key)]; ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func (f *fakeNakama) StorageWrite(ctx context.Context, writes []*runtime.StorageWrite) ([]*api.StorageObjectAck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.applyLocked(writes, nil)
+}
+
+func (f *fakeNakama) StorageDelete(ctx context.Context, deletes []*runtime.StorageDelete) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.applyLocked(nil, deletes)
+	return err
+}
+
+func (f *fakeNakama) MultiUpdate(ctx context.Context, accountUpdates []*runtime.AccountUpdate, storageWrites []*runtime.StorageWrite, storageDeletes []*runtime.StorageDelete, walletUpdates []*runtime.WalletUpdate, updateLedger bool) ([]*api.StorageObjectAck, []*runtime.WalletUpdateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	acks, err := f.applyLocked(storageWrites, storageDeletes)
+	return acks, nil, err
+}
+
+// applyLocked checks every version condition before changing anything, so a batch applies all or nothing
+func (f *fakeNakama) applyLocked(writes []*runtime.StorageWrite, deletes []*runtime.StorageDelete) ([]*api.StorageObjectAck, error) {
+	for _, write := range writes {
+		current, exists := f.objects[storageKey(write.Collection, write.UserID, write.Key)]
+		switch {
+		case write.Version == "":
+		case write.Version == "*" && exists:
+			return nil, errVersionConflict
+		case write.Version != "*" && (!exists || current.Version != write.Version):
+			return nil, errVersionConflict
+		}
+	}
+	for _, del := range deletes {
+		current, exists := f.objects[storageKey(del.Collection, del.UserID, del.Key)]
+		if del.Version != "" && (!exists || current.Version != del.Version) {
+			return nil, errVersionConflict
+		}
+	}
+
+	acks := make([]*api.StorageObjectAck, 0, len(writes))
+	for _, write := range writes {
+		f.versions++
+		obj := &api.StorageObject{
+			Collection: write.Collection,
+			Key:        write.Key,
+			UserId:     write.UserID,
+			Value:      write.Value,
+			Version:    fmt.Sprintf("v%d", f.versions),
+		}
+		f.objects[storageKey(write.Collection, write.UserID, write.Key)] = obj
+		acks = append(acks, &api.StorageObjectAck{Collection: obj.Collection, Key: obj.Key, Version: obj.Version, UserId: obj.UserId})
+	}
+	for _, del := range deletes {
+		delete(f.objects, storageKey(del.Collection, del.UserID, del.Key))
+	}
+	return acks, nil
+}
+
+func (f *fakeNakama) StorageList(ctx context.Context, callerID, userID, collection string, limit int, cursor string) ([]*api.StorageObject, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects := make([]*api.StorageObject, 0)
+	for _, obj := range f.objects {
+		if obj.Collection == collection && (userID == "" || obj.UserId == userID) {
+			objects = append(objects, obj)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, "", nil
+}
+
+func (f *fakeNakama) MatchCreate(ctx context.Context, module string, params map[string]interface{}) (string, error) {
+	if f.onMatchCreate != nil {
+		f.onMatchCreate()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	matchID := fmt.Sprintf("match-%d.nakama", len(f.matches)+1)
+	f.matches = append(f.matches, matchID)
+	return matchID, nil
+}
+
+func (f *fakeNakama) NotificationSend(ctx context.Context, userID, subject string, content map[string]interface{}, code int, sender string, persistent bool) error {
+	return nil
+}
+
+func (f *fakeNakama) matchCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.matches)
+}
+
+// fakeLogger discards log output
+type fakeLogger struct {
+	runtime.Logger
+}
+
+func (fakeLogger) Debug(format string, v ...interface{}) {}
+func (fakeLogger) Info(format string, v ...interface{})  {}
+func (fakeLogger) Warn(format string, v ...interface{})  {}
+func (fakeLogger) Error(format string, v ...interface{}) {}
+
+func userContext(userID string) context.Context {
+	return context.WithValue(context.Background(), runtime.RUNTIME_CTX_USER_ID, userID)
+}
+
+func joinQueue(t *testing.T, nk *fakeNakama, userID string, request JoinQueueRequest) JoinQueueResponse {
+	t.Helper()
+
+	payload, _ := json.Marshal(request)
+	out, err := RpcJoinQueue(userContext(userID), fakeLogger{}, nil, nk, string(payload))
+	if err != nil {
+		t.Fatalf("join_queue for %s failed: %v", userID, err)
+	}
+
+	var response JoinQueueResponse
+	if err := json.Unmarshal([]byte(out), &response); err != nil {
+		t.Fatalf("bad join_queue response %q: %v", out, err)
+	}
+	return response
+}
+
+func TestClaimQueueTicketConcurrent(t *testing.T) {
+	nk := newFakeNakama()
+	ctx := context.Background()
+
+	entry := &MatchmakingQueue{UserID: "waiting", GameMode: GameModeCasual, Token: "ticket"}
+	if err := AddToQueue(ctx, nk, entry); err != nil {
+		t.Fatal(err)
+	}
+	_, version, err := GetQueueEntry(ctx, nk, entry.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const callers = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claims := 0
+	start := make(chan struct{})
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			claimed, err := ClaimQueueTicket(ctx, nk, entry, version)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if claimed {
+				mu.Lock()
+				claims++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if claims != 1 {
+		t.Fatalf("expected exactly one claim, got %d", claims)
+	}
+
+	result, err := GetQueueTicketResult(ctx, nk, entry.UserID, entry.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || !result.Pending {
+		t.Fatalf("expected a pending result for the claimed ticket, got %+v", result)
+	}
+}
+
+func TestJoinQueueConcurrentCallersPairWaitingPlayerOnce(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		nk := newFakeNakama()
+
+		waiting := joinQueue(t, nk, "waiting", JoinQueueRequest{GameMode: GameModeCasual})
+		if waiting.Matched {
+			t.Fatal("first player should wait")
+		}
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		responses := make([]JoinQueueResponse, 2)
+		for i, userID := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(i int, userID string) {
+				defer wg.Done()
+				<-start
+				responses[i] = joinQueue(t, nk, userID, JoinQueueRequest{GameMode: GameModeCasual})
+			}(i, userID)
+		}
+		close(start)
+		wg.Wait()
+
+		// However the race goes, the waiting player lands in exactly one match
+		result, err := GetQueueTicketResult(context.Background(), nk, "waiting", waiting.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result == nil || result.Pending {
+			t.Fatalf("round %d: waiting player was not paired: %+v", round, result)
+		}
+
+		matchedWithWaiting := 0
+		for _, response := range responses {
+			if response.Matched && response.MatchID == result.MatchID {
+				matchedWithWaiting++
+			}
+		}
+		if matchedWithWaiting != 1 {
+			t.Fatalf("round %d: expected one caller in the waiting player's match, got %d", round, matchedWithWaiting)
+		}
+		if responses[0].Matched && responses[1].Matched && responses[0].MatchID != responses[1].MatchID {
+			t.Fatalf("round %d: waiting player was paired into two matches", round)
+		}
+	}
+}
+
+func TestJoinQueueRejoinWhileOpponentIsClaiming(t *testing.T) {
+	nk := newFakeNakama()
+
+	waiting := joinQueue(t, nk, "waiting", JoinQueueRequest{GameMode: GameModeCasual})
+	if waiting.Matched {
+		t.Fatal("first player should wait")
+	}
+
+	// Hold the opponent inside match creation, after they claimed the waiting entry
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	nk.onMatchCreate = func() {
+		close(entered)
+		<-release
+	}
+
+	opponentDone := make(chan JoinQueueResponse)
+	go func() {
+		opponentDone <- joinQueue(t, nk, "opponent", JoinQueueRequest{GameMode: GameModeCasual})
+	}()
+	<-entered
+	nk.onMatchCreate = nil
+
+	rejoin := joinQueue(t, nk, "waiting", JoinQueueRequest{GameMode: GameModeCasual, Token: waiting.Token})
+	if !rejoin.Pending || rejoin.Matched {
+		t.Fatalf("expected a pending response while the opponent is pairing, got %+v", rejoin)
+	}
+	if entry, _, err := GetQueueEntry(context.Background(), nk, "waiting"); err != nil || entry != nil {
+		t.Fatalf("re-join must not queue the player again, got %+v (%v)", entry, err)
+	}
+
+	close(release)
+	opponent := <-opponentDone
+	if !opponent.Matched {
+		t.Fatalf("opponent should be matched, got %+v", opponent)
+	}
+
+	rejoin = joinQueue(t, nk, "waiting", JoinQueueRequest{GameMode: GameModeCasual, Token: waiting.Token})
+	if !rejoin.Matched || rejoin.MatchID != opponent.MatchID {
+		t.Fatalf("expected the opponent's match %s, got %+v", opponent.MatchID, rejoin)
+	}
+	if count := nk.matchCount(); count != 1 {
+		t.Fatalf("expected one match, got %d", count)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// JoinCodeLength is the number of characters in a private match join code
+	JoinCodeLength = 6
+
+	// JoinCodeTTL is how long a join code stays valid if the game never starts
+	JoinCodeTTL = 30 * time.Minute
+
+	// joinCodeAlphabet leaves out characters that are easily confused (0/O, 1/I)
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+	// maxJoinCodeAttempts bounds retries when a generated code is already taken
+	maxJoinCodeAttempts = 5
+)
+
+// CreatePrivateMatchRequest represents a request to create an invite-only match
+type CreatePrivateMatchRequest struct {
+	GameMode  string   `json:"game_mode,omitempty"` // "casual" (default) or "ranked"
+	Variant   string   `json:"variant,omitempty"`
+	WinLength int      `json:"win_length,omitempty"`
+	Series    int      `json:"series_length,omitempty"` // Best-of-N set length: 1 (default), 3, 5 or 7
+	Invite    []string `json:"invite,omitempty"`        // User IDs admitted without the code
+}
+
+// CreatePrivateMatchResponse carries the new match and the code friends join it with
+type CreatePrivateMatchResponse struct {
+	MatchID   string `json:"match_id"`
+	JoinCode  string `json:"join_code"`
+	ExpiresAt int64  `json:"expires_at"` // Unix ms
+	GameMode  string `json:"game_mode"`
+	Variant   string `json:"variant"`
+	Series    int    `json:"series_length"`
+}
+
+// JoinByCodeRequest represents a request to join a private match by its code
+type JoinByCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// JoinByCodeResponse carries the match the caller has been admitted to
+type JoinByCodeResponse struct {
+	MatchID string `json:"match_id"`
+}
+
+// PrivateMatchCode maps a join code to its match
+type PrivateMatchCode struct {
+	Code      string `json:"code"`
+	MatchID   string `json:"match_id"`
+	Creator   string `json:"creator"`
+	ExpiresAt int64  `json:"expires_at"` // Unix ms
+}
+
+// RpcCreatePrivateMatch creates a match that only invited players and holders of its join code may enter
+func RpcCreatePrivateMatch(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	var request CreatePrivateMatchRequest
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &request); err != nil {
+			logger.Error("Failed to unmarshal request: %v", err)
+			return "", runtime.NewError("invalid request payload", 3)
+		}
+	}
+
+	if request.GameMode == "" {
+		request.GameMode = GameModeCasual
+	}
+	if request.GameMode != GameModeCasual && request.GameMode != GameModeRanked {
+		return "", runtime.NewError("invalid game_mode, must be 'casual' or 'ranked'", 3)
+	}
+
+	variant, err := LookupVariant(request.Variant, request.WinLength)
+	if err != nil {
+		return "", runtime.NewError("invalid variant", 3)
+	}
+
+	if request.Series == 0 {
+		request.Series = 1
+	}
+	if !ValidSeriesLength(request.Series) {
+		return "", runtime.NewError("invalid series_length, must be 1, 3, 5 or 7", 3)
+	}
+
+	// Reserve a code before creating the match so the match knows its own code
+	code, err := ReserveJoinCode(ctx, nk, userID)
+	if err != nil {
+		logger.Error("Failed to reserve join code: %v", err)
+		return "", runtime.NewError("failed to create match", 13)
+	}
+
+	allowed := append([]string{userID}, request.Invite...)
+	matchID, err := nk.MatchCreate(ctx, "tictactoe", map[string]interface{}{
+		"game_mode":     request.GameMode,
+		"variant":       variant.Name,
+		"win_length":    variant.WinLength,
+		"series_length": request.Series,
+		"private":       true,
+		"join_code":     code.Code,
+		"allowed":       allowed,
+	})
+	if err != nil {
+		logger.Error("Failed to create private match: %v", err)
+		DeleteJoinCode(ctx, logger, nk, code.Code)
+		return "", runtime.NewError("failed to create match", 13)
+	}
+
+	code.MatchID = matchID
+	if err := saveJoinCode(ctx, nk, code, ""); err != nil {
+		logger.Error("Failed to save join code: %v", err)
+
+		// The creator never learns the match ID, so take the match and its code down
+		DeleteJoinCode(ctx, logger, nk, code.Code)
+		if _, _, err := SignalLiveMatch(ctx, nk, matchID, &MatchSignalRequest{Action: SignalActionClose}); err != nil {
+			logger.Error("Failed to close private match %s: %v", matchID, err)
+		}
+		return "", runtime.NewError("failed to create match", 13)
+	}
+
+	logger.Info("Created private match - ID: %s, Creator: %s, Code: %s", matchID, userID, code.Code)
+
+	responseJSON, err := json.Marshal(CreatePrivateMatchResponse{
+		MatchID:   matchID,
+		JoinCode:  code.Code,
+		ExpiresAt: code.ExpiresAt,
+		GameMode:  request.GameMode,
+		Variant:   variant.Name,
+		Series:    request.Series,
+	})
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// RpcJoinByCode admits the caller to the private match behind a join code
+func RpcJoinByCode(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userID, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userID == "" {
+		return "", runtime.NewError("user not authenticated", 16)
+	}
+
+	var request JoinByCodeRequest
+	if err := json.Unmarshal([]byte(payload), &request); err != nil {
+		logger.Error("Failed to unmarshal request: %v", err)
+		return "", runtime.NewError("invalid request payload", 3)
+	}
+
+	request.Code = strings.ToUpper(strings.TrimSpace(request.Code))
+	if request.Code == "" {
+		return "", runtime.NewError("code is required", 3)
+	}
+
+	code, err := LoadJoinCode(ctx, nk, request.Code)
+	if err != nil {
+		logger.Error("Failed to load join code: %v", err)
+		return "", runtime.NewError("failed to join match", 13)
+	}
+	if code == nil || code.MatchID == "" || time.Now().UnixMilli() >= code.ExpiresAt {
+		return "", runtime.NewError("invalid or expired code", 5)
+	}
+
+	// The match keeps its own allow-list, so admission goes through the match
+	response, live, err := SignalLiveMatch(ctx, nk, code.MatchID, &MatchSignalRequest{
+		Action: SignalActionAdmit,
+		UserID: userID,
+	})
+	if err != nil {
+		logger.Error("Failed to signal match: %v", err)
+		return "", runtime.NewError("failed to join match", 13)
+	}
+	if !live {
+		DeleteJoinCode(ctx, logger, nk, code.Code)
+		return "", runtime.NewError("invalid or expired code", 5)
+	}
+	if !response.Success {
+		return "", runtime.NewError(response.Message, 9)
+	}
+
+	logger.Info("Admitted to private match - Match: %s, UserID: %s", code.MatchID, userID)
+
+	responseJSON, err := json.Marshal(JoinByCodeResponse{MatchID: code.MatchID})
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// ReserveJoinCode generates an unused join code and stores it without a match yet
+func ReserveJoinCode(ctx context.Context, nk runtime.NakamaModule, creator string) (*PrivateMatchCode, error) {
+	var err error
+	for attempt := 0; attempt < maxJoinCodeAttempts; attempt++ {
+		var value string
+		value, err = generateJoinCode()
+		if err != nil {
+			return nil, err
+		}
+
+		code := &PrivateMatchCode{
+			Code:      value,
+			Creator:   creator,
+			ExpiresAt: time.Now().Add(JoinCodeTTL).UnixMilli(),
+		}
+
+		// Only succeeds if no other match holds the code
+		if err = saveJoinCode(ctx, nk, code, "*"); err == nil {
+			return code, nil
+		}
+	}
+	return nil, err
+}
+
+// LoadJoinCode reads a join code, returning nil if it does not exist
+func LoadJoinCode(ctx context.Context, nk runtime.NakamaModule, value string) (*PrivateMatchCode, error) {
+	objects, err := nk.StorageRead(ctx, []*runtime.StorageRead{
+		{
+			Collection: "private_matches",
+			Key:        value,
+			UserID:     "",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	var code PrivateMatchCode
+	if err := json.Unmarshal([]byte(objects[0].Value), &code); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// DeleteJoinCode retires a join code once its match no longer needs it
+func DeleteJoinCode(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, value string) {
+	err := nk.StorageDelete(ctx, []*runtime.StorageDelete{
+		{
+			Collection: "private_matches",
+			Key:        value,
+			UserID:     "",
+		},
+	})
+	if err != nil {
+		logger.Error("Failed to delete join code %s: %v", value, err)
+	}
+}
+
+// ExpireJoinCodes deletes join codes past their expiry whose match never started
+func ExpireJoinCodes(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule) (int, error) {
+	now := time.Now().UnixMilli()
+	expired := 0
+
+	cursor := ""
+	for {
+		objects, nextCursor, err := nk.StorageList(ctx, "", "", "private_matches", 100, cursor)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, obj := range objects {
+			var code PrivateMatchCode
+			if err := json.Unmarshal([]byte(obj.Value), &code); err != nil {
+				continue
+			}
+			if now < code.ExpiresAt {
+				continue
+			}
+
+			// Skip codes changed since they were listed
+			err := nk.StorageDelete(ctx, []*runtime.StorageDelete{
+				{
+					Collection: "private_matches",
+					Key:        obj.Key,
+					UserID:     "",
+					Version:    obj.Version,
+				},
+			})
+			if err != nil {
+				continue
+			}
+			expired++
+			logger.Debug("Expired join code %s for match %s", code.Code, code.MatchID)
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	return expired, nil
+}
+
+// saveJoinCode writes a join code; version "*" only creates a new one
+func saveJoinCode(ctx context.Context, nk runtime.NakamaModule, code *PrivateMatchCode, version string) error {
+	data, err := json.Marshal(code)
+	if err != nil {
+		return err
+	}
+
+	_, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{
+		{
+			Collection:      "private_matches",
+			Key:             code.Code,
+			UserID:          "",
+			Value:           string(data),
+			Version:         version,
+			PermissionRead:  0, // No client read, codes are only resolved by join_by_code
+			PermissionWrite: 0, // No client write
+		},
+	})
+	return err
+}
+
+// generateJoinCode returns a random code of JoinCodeLength characters
+func generateJoinCode() (string, error) {
+	alphabetSize := big.NewInt(int64(len(joinCodeAlphabet)))
+	code := make([]byte, JoinCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate join code: %w", err)
+		}
+		code[i] = joinCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// stringsParam reads a list of strings from a match parameter, accepting both
+// Go slices and JSON-decoded arrays
+func stringsParam(params map[string]interface{}, key string) []string {
+	switch v := params[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// SeriesScore is the running score of the games played in one match. For a
+// best-of-N set it also holds the set's length and, once decided, its winner.
+type SeriesScore struct {
+	Length  int            `json:"length"` // Games in the set, 1 = single games
+	Games   int            `json:"games"`
+	Wins    map[string]int `json:"wins"` // Games won per user ID
+	Draws   int            `json:"draws"`
+	Decided bool           `json:"decided"`
+	Winner  string         `json:"winner,omitempty"` // Empty for a drawn or undecided set
+}
+
+// RematchMessage is broadcast with the rematch opcodes
+type RematchMessage struct {
+	UserID string      `json:"user_id"`        // Player who requested, accepted or declined
+	Game   int         `json:"game,omitempty"` // Number of the new game once a rematch starts
+	Series SeriesScore `json:"series"`
+}
+
+// NewSeriesScore creates an empty scoreboard for sets of the given length
+func NewSeriesScore(length int) SeriesScore {
+	return SeriesScore{Length: length, Wins: make(map[string]int)}
+}
+
+// Record adds a finished game to the scoreboard; voided games don't count
+func (s *SeriesScore) Record(gs *GameState) {
+	switch {
+	case gs.Result == GameResultVoid:
+		return
+	case gs.Result == GameResultDraw:
+		s.Draws++
+	case gs.Winner != "":
+		s.Wins[gs.Winner]++
+	}
+	s.Games++
+}
+
+// handleRematch processes a rematch request, acceptance or refusal. A new game
+// starts once both players have agreed; the bot always agrees.
+func (m *TicTacToeMatch) handleRematch(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState, opCode int64, userID string) error {
+	gameState := matchState.GameState
+	if gameState == nil || gameState.Status != GameStatusFinished {
+		return fmt.Errorf("game is not over")
+	}
+	if matchState.Series.IsSeries() && !matchState.Series.Decided {
+		return fmt.Errorf("series in progress")
+	}
+
+	if opCode == OpCodeRematchDecline {
+		matchState.Rematch = make(map[string]bool)
+		logger.Info("Rematch declined - Match: %s, UserID: %s", matchState.MatchID, userID)
+		m.broadcastRematch(dispatcher, matchState, OpCodeRematchDecline, userID)
+		return nil
+	}
+
+	opponent := gameState.PlayerX
+	if userID == gameState.PlayerX {
+		opponent = gameState.PlayerO
+	}
+	if opCode == OpCodeRematchAccept && !matchState.Rematch[opponent] {
+		return fmt.Errorf("no rematch requested")
+	}
+	if _, ok := matchState.PresenceList[opponent]; !ok && !IsBotUser(opponent) {
+		return fmt.Errorf("opponent has left")
+	}
+
+	matchState.Rematch[userID] = true
+	if IsBotUser(opponent) {
+		matchState.Rematch[opponent] = true
+	}
+
+	if !matchState.Rematch[opponent] {
+		logger.Info("Rematch requested - Match: %s, UserID: %s", matchState.MatchID, userID)
+		m.broadcastRematch(dispatcher, matchState, OpCodeRematchRequest, userID)
+		return nil
+	}
+
+	// A rematch after a decided set plays a new set of the same length
+	if matchState.Series.Decided {
+		matchState.Series = NewSeriesScore(matchState.Series.Length)
+	}
+
+	m.startNextGame(ctx, logger, nk, dispatcher, matchState)
+	m.broadcastRematch(dispatcher, matchState, OpCodeRematchAccept, userID)
+	m.broadcastGameState(dispatcher, matchState.GameState)
+	return nil
+}
+
+// startNextGame replaces the finished game with a fresh one in the same match,
+// with the players' symbols swapped so the other player moves first
+func (m *TicTacToeMatch) startNextGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState) {
+	previous := matchState.GameState
+
+	next := matchState.NewGame(previous.PlayerO, previous.PlayerX)
+	next.Game = max(previous.Game, 1) + 1
+	next.FirstMove = FirstMoveAlternate
+	next.BotDifficulty = previous.BotDifficulty
+	next.BotRating = previous.BotRating
+	next.BotBackfill = previous.BotBackfill
+	next.StartTurnClock(time.Now())
+
+	matchState.GameState = next
+	matchState.Rematch = make(map[string]bool)
+	matchState.BotMoveTick = 0
+
+	m.saveGameState(ctx, logger, nk, matchState)
+	if err := SaveCurrentGame(ctx, nk, next); err != nil {
+		logger.Error("Failed to record current game: %v", err)
+	}
+	m.updateLabel(logger, dispatcher, matchState)
+
+	logger.Info("Next game started - Match: %s, Game: %d, X: %s, O: %s", matchState.MatchID, next.Game, next.PlayerX, next.PlayerO)
+}
+
+// broadcastRematch tells everyone in the match about a rematch event
+func (m *TicTacToeMatch) broadcastRematch(dispatcher runtime.MatchDispatcher, matchState *MatchState, opCode int64, userID string) {
+	message := RematchMessage{
+		UserID: userID,
+		Series: matchState.Series,
+	}
+	if opCode == OpCodeRematchAccept {
+		message.Game = matchState.GameState.Game
+	}
+	messageJSON, _ := json.Marshal(message)
+
+	envelope := &MatchMessage{
+		OpCode: opCode,
+		Data:   messageJSON,
+	}
+	envelopeJSON, _ := json.Marshal(envelope)
+
+	dispatcher.BroadcastMessage(opCode, envelopeJSON, nil, nil, true)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// GetReplayRequest represents a request for a game's move history
+type GetReplayRequest struct {
+	MatchID  string `json:"match_id"`
+	Position *int   `json:"position,omitempty"` // Moves to replay for an intermediate position, omit for none
+}
+
+// ReplayResponse carries the move history and, if asked for, a reconstructed position
+type ReplayResponse struct {
+	MatchID   string       `json:"match_id"`
+	Variant   string       `json:"variant"`
+	BoardSize int          `json:"board_size"`
+	WinLength int          `json:"win_length"`
+	PlayerX   string       `json:"player_x"`
+	PlayerO   string       `json:"player_o"`
+	Status    GameStatus   `json:"status"`
+	Result    GameResult   `json:"result"`
+	Winner    string       `json:"winner"`
+	Moves     []MoveRecord `json:"moves"`
+	Position  *GameState   `json:"position,omitempty"` // Game as it stood after Position moves
+}
+
+// RpcGetReplay returns the move history of a game, optionally with the board
+// rebuilt after any number of moves so clients can scrub through it
+func RpcGetReplay(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var request GetReplayRequest
+	if err := json.Unmarshal([]byte(payload), &request); err != nil {
+		logger.Error("Failed to unmarshal request: %v", err)
+		return "", runtime.NewError("invalid request payload", 3)
+	}
+
+	if request.MatchID == "" {
+		return "", runtime.NewError("match_id is required", 3)
+	}
+
+	gameID, err := ResolveGameID(ctx, nk, request.MatchID)
+	if err != nil {
+		logger.Error("Failed to resolve current game: %v", err)
+		return "", runtime.NewError("failed to load game state", 13)
+	}
+
+	gameState, err := LoadGameState(ctx, nk, gameID)
+	if err != nil {
+		logger.Error("Failed to load game state: %v", err)
+		return "", runtime.NewError("game not found", 5)
+	}
+
+	response := ReplayResponse{
+		MatchID:   gameState.MatchID,
+		Variant:   gameState.Variant,
+		BoardSize: gameState.BoardSize,
+		WinLength: gameState.WinLength,
+		PlayerX:   gameState.PlayerX,
+		PlayerO:   gameState.PlayerO,
+		Status:    gameState.Status,
+		Result:    gameState.Result,
+		Winner:    gameState.Winner,
+		Moves:     gameState.Moves,
+	}
+	if response.Moves == nil {
+		response.Moves = []MoveRecord{}
+	}
+
+	if request.Position != nil {
+		if *request.Position < 0 || *request.Position > len(gameState.Moves) {
+			return "", runtime.NewError(fmt.Sprintf("position must be between 0 and %d", len(gameState.Moves)), 3)
+		}
+
+		position, err := ReplayPosition(gameState, *request.Position)
+		if err != nil {
+			logger.Error("Failed to replay game %s: %v", request.MatchID, err)
+			return "", runtime.NewError("failed to replay game", 13)
+		}
+		response.Position = position
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("Failed to marshal response: %v", err)
+		return "", runtime.NewError("failed to create response", 13)
+	}
+
+	return string(responseJSON), nil
+}
+
+// ReplayPosition rebuilds a game as it stood after the first n moves of its history
+func ReplayPosition(gs *GameState, n int) (*GameState, error) {
+	variant := BoardVariant{Name: gs.Variant, Size: gs.BoardSize, WinLength: gs.WinLength}
+	replay := NewGameState(gs.MatchID, gs.PlayerX, gs.PlayerO, gs.GameMode, variant)
+
+	for i, record := range gs.Moves[:n] {
+		if err := replay.PlayMove(record.Move(), record.Player, record.Time(), record.Tick); err != nil {
+			return nil, fmt.Errorf("move %d does not replay: %w", i+1, err)
+		}
+	}
+
+	// Games that ended by resignation or on time finish after their last move
+	if n == len(gs.Moves) && gs.Status == GameStatusFinished {
+		replay.Status = gs.Status
+		replay.Result = gs.Result
+		replay.Winner = gs.Winner
+	}
+
+	return replay, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Rules implements the gameplay of a variant. GameState handles turn order,
+// clocks and results; the rules own the board and any VariantState, and decide
+// when the game ends. Rules are registered in BoardVariants.
+type Rules interface {
+	// NewState sets up the empty board of a newly created game
+	NewState(gs *GameState)
+	// ValidateMove checks a move by the player to move
+	ValidateMove(gs *GameState, move Move) error
+	// ApplyMove places a validated move for the player to move
+	ApplyMove(gs *GameState, move Move)
+	// LegalMoves lists every move the player to move may make
+	LegalMoves(gs *GameState) []Move
+	// Outcome returns the result if the game is over, GameResultNone otherwise
+	Outcome(gs *GameState) GameResult
+	// Cell returns the mark in the cell a move targets
+	Cell(gs *GameState, move Move) PlayerSymbol
+	// Serialize encodes the game's VariantState, or returns nil if the variant keeps none
+	Serialize(gs *GameState) (json.RawMessage, error)
+	// Restore decodes the VariantState of a game loaded from storage, nil if none
+	// was stored, and checks the board
+	Restore(gs *GameState, data json.RawMessage) error
+}
+
+// VariantState is game state a variant keeps beyond the shared board
+type VariantState interface {
+	Clone() VariantState
+}
+
+// LineRules is the standard game: WinLength marks in a row on a single board wins
+type LineRules struct{}
+
+// NewState creates an empty BoardSize x BoardSize board
+func (LineRules) NewState(gs *GameState) {
+	gs.Board = newBoard(gs.BoardSize)
+}
+
+// ValidateMove checks the move is on the board and the cell is empty
+func (LineRules) ValidateMove(gs *GameState, move Move) error {
+	// Check if move is within bounds
+	if move.Row < 0 || move.Row >= gs.BoardSize || move.Col < 0 || move.Col >= gs.BoardSize {
+		return fmt.Errorf("move out of bounds")
+	}
+
+	// Check if cell is empty
+	if gs.Board[move.Row][move.Col] != SymbolEmpty {
+		return fmt.Errorf("cell already occupied")
+	}
+
+	return nil
+}
+
+// ApplyMove places the current player's symbol
+func (LineRules) ApplyMove(gs *GameState, move Move) {
+	gs.Board[move.Row][move.Col] = gs.CurrentPlayer
+}
+
+// LegalMoves lists the empty cells
+func (LineRules) LegalMoves(gs *GameState) []Move {
+	moves := make([]Move, 0, gs.BoardSize*gs.BoardSize-gs.MoveCount)
+	for row := range gs.Board {
+		for col, cell := range gs.Board[row] {
+			if cell == SymbolEmpty {
+				moves = append(moves, Move{Row: row, Col: col})
+			}
+		}
+	}
+	return moves
+}
+
+// Outcome checks the player who just moved for a line, then for a full board
+func (LineRules) Outcome(gs *GameState) GameResult {
+	if HasLine(gs.Board, gs.CurrentPlayer, gs.WinLength) {
+		return resultFor(gs.CurrentPlayer)
+	}
+	if BoardFull(gs.Board) {
+		return GameResultDraw
+	}
+	return GameResultNone
+}
+
+// Cell returns the mark on the board
+func (LineRules) Cell(gs *GameState, move Move) PlayerSymbol {
+	return gs.Board[move.Row][move.Col]
+}
+
+// Serialize stores nothing; the board is all there is
+func (LineRules) Serialize(gs *GameState) (json.RawMessage, error) {
+	return nil, nil
+}
+
+// Restore checks the board matches the stored board size
+func (LineRules) Restore(gs *GameState, data json.RawMessage) error {
+	if len(gs.Board) != gs.BoardSize {
+		return fmt.Errorf("board does not match board size %d", gs.BoardSize)
+	}
+	return nil
+}
+
+// HasLine reports whether symbol has winLength marks in a row on a square board
+func HasLine(board [][]PlayerSymbol, symbol PlayerSymbol, winLength int) bool {
+	// Directions: right, down, down-right, down-left
+	directions := [4][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+
+	for row := range board {
+		for col := range board[row] {
+			if board[row][col] != symbol {
+				continue
+			}
+			for _, d := range directions {
+				if countLine(board, row, col, d[0], d[1], symbol) >= winLength {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// countLine counts consecutive cells holding symbol starting at (row, col) in direction (dRow, dCol)
+func countLine(board [][]PlayerSymbol, row, col, dRow, dCol int, symbol PlayerSymbol) int {
+	size := len(board)
+	count := 0
+	for row >= 0 && row < size && col >= 0 && col < size && board[row][col] == symbol {
+		count++
+		row += dRow
+		col += dCol
+	}
+	return count
+}
+
+// BoardFull reports whether every cell of a board is occupied
+func BoardFull(board [][]PlayerSymbol) bool {
+	for _, row := range board {
+		for _, cell := range row {
+			if cell == SymbolEmpty {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// resultFor returns the winning result for a symbol
+func resultFor(symbol PlayerSymbol) GameResult {
+	if symbol == SymbolX {
+		return GameResultXWins
+	}
+	return GameResultOWins
+}
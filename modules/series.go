@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// SeriesGamePause is how long players see a finished game before the next game of a set starts
+const SeriesGamePause = 3 * time.Second
+
+// SeriesMessage is broadcast with OpCodeSeries after each game of a best-of-N set
+type SeriesMessage struct {
+	Series     SeriesScore `json:"series"`
+	NextGameAt int64       `json:"next_game_at,omitempty"` // Unix ms the next game starts, 0 once the set is decided
+}
+
+// ValidSeriesLength reports whether a best-of-N length is supported
+func ValidSeriesLength(length int) bool {
+	switch length {
+	case 1, 3, 5, 7:
+		return true
+	}
+	return false
+}
+
+// IsSeries reports whether the score belongs to a best-of-N set rather than single games
+func (s *SeriesScore) IsSeries() bool {
+	return s.Length > 1
+}
+
+// Decide settles the set once neither player can catch up in the games left,
+// reporting whether it is decided. A set that ends level is drawn.
+func (s *SeriesScore) Decide(playerA, playerB string) bool {
+	if !s.IsSeries() || s.Decided {
+		return s.Decided
+	}
+
+	winsA, winsB := s.Wins[playerA], s.Wins[playerB]
+	remaining := s.Length - s.Games
+	switch {
+	case winsA > winsB+remaining:
+		s.Winner = playerA
+	case winsB > winsA+remaining:
+		s.Winner = playerB
+	case remaining > 0:
+		return false
+	}
+
+	s.Decided = true
+	return true
+}
+
+// SetResult returns a game record standing for the whole decided set, with
+// the seats of the deciding game
+func (s *SeriesScore) SetResult(last *GameState) *GameState {
+	set := &GameState{
+		MatchID:       last.MatchID,
+		Variant:       last.Variant,
+		PlayerX:       last.PlayerX,
+		PlayerO:       last.PlayerO,
+		Status:        GameStatusFinished,
+		Result:        GameResultDraw,
+		Winner:        s.Winner,
+		GameMode:      last.GameMode,
+		BotDifficulty: last.BotDifficulty,
+		SeriesLength:  s.Length,
+		EndedAt:       last.EndedAt,
+	}
+	switch s.Winner {
+	case last.PlayerX:
+		set.Result = GameResultXWins
+	case last.PlayerO:
+		set.Result = GameResultOWins
+	}
+	return set
+}
+
+// recordSeriesGame records a finished game of a best-of-N set. Every game goes
+// into match history, but ratings and records only move once the set is
+// decided; the deciding game carries the set's rating change.
+func (m *TicTacToeMatch) recordSeriesGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, matchState *MatchState) {
+	gameState := matchState.GameState
+	if gameState.EndedAt == 0 {
+		gameState.EndedAt = time.Now().UnixMilli()
+	}
+
+	series := &matchState.Series
+	if series.Decide(gameState.PlayerX, gameState.PlayerO) {
+		set := series.SetResult(gameState)
+		if err := updateRecords(ctx, logger, nk, set); err != nil {
+			logger.Error("Failed to update player stats for series: %v", err)
+		}
+		gameState.RatingChangeX = set.RatingChangeX
+		gameState.RatingChangeO = set.RatingChangeO
+
+		logger.Info("Series decided - Match: %s, Winner: %s, Games: %d", matchState.MatchID, series.Winner, series.Games)
+	} else {
+		matchState.NextGameAt = time.Now().Add(SeriesGamePause).UnixMilli()
+	}
+
+	recordGameHistory(ctx, logger, nk, gameState)
+}
+
+// startSeriesGame starts the next game of an undecided set. Players who are
+// not connected get the usual reconnect grace to come back for it.
+func (m *TicTacToeMatch) startSeriesGame(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, dispatcher runtime.MatchDispatcher, matchState *MatchState) {
+	matchState.NextGameAt = 0
+	m.startNextGame(ctx, logger, nk, dispatcher, matchState)
+
+	deadline := time.Now().Add(time.Duration(max(matchState.ReconnectGrace, 0)) * time.Second).UnixMilli()
+	for _, userID := range []string{matchState.GameState.PlayerX, matchState.GameState.PlayerO} {
+		if _, ok := matchState.PresenceList[userID]; !ok && !IsBotUser(userID) {
+			matchState.Disconnected[userID] = deadline
+		}
+	}
+
+	m.broadcastGameState(dispatcher, matchState.GameState)
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestSeriesScoreDecide(t *testing.T) {
+	tests := []struct {
+		name        string
+		length      int
+		games       []string // Winner of each game: "a", "b", "draw" or "void"
+		wantDecided int      // Game after which the set is decided, 0 = never
+		wantWinner  string
+	}{
+		{name: "single games are never a set", length: 1, games: []string{"a"}},
+		{name: "two straight wins take a best of three", length: 3, games: []string{"a", "a"}, wantDecided: 2, wantWinner: "a"},
+		{name: "best of three goes the distance", length: 3, games: []string{"a", "b", "a"}, wantDecided: 3, wantWinner: "a"},
+		{name: "one win and two draws", length: 3, games: []string{"a", "draw", "draw"}, wantDecided: 3, wantWinner: "a"},
+		{name: "level set is drawn", length: 3, games: []string{"a", "b", "draw"}, wantDecided: 3},
+		{name: "all draws", length: 3, games: []string{"draw", "draw", "draw"}, wantDecided: 3},
+		{name: "voided games are replayed", length: 3, games: []string{"a", "void", "a"}, wantDecided: 3, wantWinner: "a"},
+		{name: "best of five decided early", length: 5, games: []string{"b", "b", "b"}, wantDecided: 3, wantWinner: "b"},
+		{name: "best of five comeback", length: 5, games: []string{"a", "a", "b", "b", "b"}, wantDecided: 5, wantWinner: "b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := NewSeriesScore(tt.length)
+			decidedAt := 0
+			for i, game := range tt.games {
+				gs := &GameState{Result: GameResultXWins, Winner: game}
+				switch game {
+				case "draw":
+					gs.Result, gs.Winner = GameResultDraw, ""
+				case "void":
+					gs.Result, gs.Winner = GameResultVoid, ""
+				}
+
+				score.Record(gs)
+				if score.Decide("a", "b") && decidedAt == 0 {
+					decidedAt = i + 1
+				}
+			}
+
+			if decidedAt != tt.wantDecided || score.Winner != tt.wantWinner {
+				t.Fatalf("decided after game %d with winner %q, want game %d and winner %q",
+					decidedAt, score.Winner, tt.wantDecided, tt.wantWinner)
+			}
+		})
+	}
+}
+
+func TestSeriesScoreSetResult(t *testing.T) {
+	last := &GameState{MatchID: "match-1", PlayerX: "b", PlayerO: "a", Status: GameStatusFinished, GameMode: GameModeRanked}
+
+	tests := []struct {
+		winner string
+		want   GameResult
+	}{
+		{winner: "a", want: GameResultOWins},
+		{winner: "b", want: GameResultXWins},
+		{winner: "", want: GameResultDraw},
+	}
+
+	for _, tt := range tests {
+		score := SeriesScore{Length: 3, Decided: true, Winner: tt.winner}
+		set := score.SetResult(last)
+		if set.Result != tt.want || set.Winner != tt.winner || set.SeriesLength != 3 || set.GameMode != GameModeRanked {
+			t.Errorf("winner %q: got %+v, want result %s", tt.winner, set, tt.want)
+		}
+	}
+}
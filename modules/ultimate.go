@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// VariantUltimate is ultimate tic-tac-toe: nine 3x3 sub-boards arranged in a
+// 3x3 grid. Winning a sub-board claims the matching cell of GameState.Board,
+// and three claimed cells in a row win the game.
+const VariantUltimate = "ultimate"
+
+// AnySubBoard means the player to move may choose any open sub-board
+const AnySubBoard = -1
+
+// UltimateState holds the sub-boards of an ultimate tic-tac-toe game. It is the
+// game's VariantState and is stored under the "ultimate" key.
+type UltimateState struct {
+	SubBoards    [][][]PlayerSymbol `json:"sub_boards"`     // Nine 3x3 boards, indexed row-major 0-8
+	SubResults   []GameResult       `json:"sub_results"`    // Result per sub-board: x_wins, o_wins, draw or none
+	NextSubBoard int                `json:"next_sub_board"` // Sub-board the player to move must use, -1 = any open
+}
+
+// NewUltimateState creates an empty set of sub-boards
+func NewUltimateState() *UltimateState {
+	us := &UltimateState{
+		SubBoards:    make([][][]PlayerSymbol, 9),
+		SubResults:   make([]GameResult, 9),
+		NextSubBoard: AnySubBoard,
+	}
+	for i := range us.SubBoards {
+		us.SubBoards[i] = newBoard(3)
+		us.SubResults[i] = GameResultNone
+	}
+	return us
+}
+
+// IsOpen reports whether a sub-board can still be played in
+func (us *UltimateState) IsOpen(subBoard int) bool {
+	return us.SubResults[subBoard] == GameResultNone
+}
+
+// UltimateRules implements ultimate tic-tac-toe, keeping the sub-boards in the game's VariantState
+type UltimateRules struct{}
+
+// ultimateState returns the sub-boards of an ultimate game
+func ultimateState(gs *GameState) *UltimateState {
+	us, _ := gs.VariantState.(*UltimateState)
+	return us
+}
+
+// NewState creates the empty overall board and sub-boards
+func (UltimateRules) NewState(gs *GameState) {
+	gs.Board = newBoard(3)
+	gs.VariantState = NewUltimateState()
+}
+
+// ValidateMove checks the move targets an open sub-board the player was sent to
+func (UltimateRules) ValidateMove(gs *GameState, move Move) error {
+	if move.SubBoard < 0 || move.SubBoard > 8 || move.Row < 0 || move.Row > 2 || move.Col < 0 || move.Col > 2 {
+		return fmt.Errorf("move out of bounds")
+	}
+
+	us := ultimateState(gs)
+	if us.NextSubBoard != AnySubBoard && move.SubBoard != us.NextSubBoard {
+		return fmt.Errorf("must play in sub-board %d", us.NextSubBoard)
+	}
+	if !us.IsOpen(move.SubBoard) {
+		return fmt.Errorf("sub-board already decided")
+	}
+
+	if us.SubBoards[move.SubBoard][move.Row][move.Col] != SymbolEmpty {
+		return fmt.Errorf("cell already occupied")
+	}
+
+	return nil
+}
+
+// ApplyMove places the mark, settles the sub-board and picks the sub-board
+// the opponent must play in next from the cell just played
+func (UltimateRules) ApplyMove(gs *GameState, move Move) {
+	us := ultimateState(gs)
+	symbol := gs.CurrentPlayer
+	board := us.SubBoards[move.SubBoard]
+
+	board[move.Row][move.Col] = symbol
+
+	// Settle the sub-board and claim it on the overall board
+	if HasLine(board, symbol, 3) {
+		us.SubResults[move.SubBoard] = resultFor(symbol)
+		gs.Board[move.SubBoard/3][move.SubBoard%3] = symbol
+	} else if BoardFull(board) {
+		us.SubResults[move.SubBoard] = GameResultDraw
+	}
+
+	// Send the opponent to the sub-board matching the cell just played
+	us.NextSubBoard = move.Row*3 + move.Col
+	if !us.IsOpen(us.NextSubBoard) {
+		us.NextSubBoard = AnySubBoard
+	}
+}
+
+// LegalMoves lists the empty cells of the sub-boards the player may use
+func (UltimateRules) LegalMoves(gs *GameState) []Move {
+	us := ultimateState(gs)
+	moves := make([]Move, 0, 9)
+	for subBoard, board := range us.SubBoards {
+		if !us.IsOpen(subBoard) || (us.NextSubBoard != AnySubBoard && subBoard != us.NextSubBoard) {
+			continue
+		}
+		for row := range board {
+			for col, cell := range board[row] {
+				if cell == SymbolEmpty {
+					moves = append(moves, Move{SubBoard: subBoard, Row: row, Col: col})
+				}
+			}
+		}
+	}
+	return moves
+}
+
+// Clone returns a deep copy of the sub-boards
+func (us *UltimateState) Clone() VariantState {
+	clone := &UltimateState{
+		SubBoards:    make([][][]PlayerSymbol, len(us.SubBoards)),
+		SubResults:   append([]GameResult(nil), us.SubResults...),
+		NextSubBoard: us.NextSubBoard,
+	}
+	for i, board := range us.SubBoards {
+		clone.SubBoards[i] = cloneBoard(board)
+	}
+	return clone
+}
+
+// Outcome checks for three claimed sub-boards in a row, and draws once
+// every sub-board is decided without one
+func (UltimateRules) Outcome(gs *GameState) GameResult {
+	us := ultimateState(gs)
+
+	if HasLine(gs.Board, gs.CurrentPlayer, 3) {
+		return resultFor(gs.CurrentPlayer)
+	}
+
+	for i := range us.SubResults {
+		if us.IsOpen(i) {
+			return GameResultNone
+		}
+	}
+	return GameResultDraw
+}
+
+// Cell returns the mark in the targeted cell of its sub-board
+func (UltimateRules) Cell(gs *GameState, move Move) PlayerSymbol {
+	return ultimateState(gs).SubBoards[move.SubBoard][move.Row][move.Col]
+}
+
+// Serialize encodes the sub-boards
+func (UltimateRules) Serialize(gs *GameState) (json.RawMessage, error) {
+	us := ultimateState(gs)
+	if us == nil {
+		return nil, nil
+	}
+	return json.Marshal(us)
+}
+
+// Restore decodes the sub-boards and checks the stored game has all of them
+func (UltimateRules) Restore(gs *GameState, data json.RawMessage) error {
+	var us UltimateState
+	if data == nil || json.Unmarshal(data, &us) != nil ||
+		len(us.SubBoards) != 9 || len(us.SubResults) != 9 || len(gs.Board) != 3 {
+		return fmt.Errorf("ultimate game is missing its sub-boards")
+	}
+	gs.VariantState = &us
+	return nil
+}
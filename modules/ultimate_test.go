@@ -0,0 +1,234 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestGame(variant string) *GameState {
+	return NewGameState("match-1", "x", "o", GameModeCasual, BoardVariants[variant])
+}
+
+// playMoves plays each move for whichever player is to move
+func playMoves(t *testing.T, gs *GameState, moves ...Move) {
+	t.Helper()
+
+	for i, move := range moves {
+		playerID := gs.PlayerX
+		if gs.CurrentPlayer == SymbolO {
+			playerID = gs.PlayerO
+		}
+		if err := gs.PlayMove(move, playerID, time.Now(), int64(i)); err != nil {
+			t.Fatalf("move %d %+v: %v", i, move, err)
+		}
+	}
+}
+
+func TestUltimateValidateMove(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(us *UltimateState)
+		move    Move
+		wantErr string
+	}{
+		{
+			name: "first move may use any sub-board",
+			move: Move{SubBoard: 8, Row: 2, Col: 2},
+		},
+		{
+			name:    "sub-board out of bounds",
+			move:    Move{SubBoard: 9},
+			wantErr: "move out of bounds",
+		},
+		{
+			name:    "must play in the sub-board sent to",
+			setup:   func(us *UltimateState) { us.NextSubBoard = 4 },
+			move:    Move{SubBoard: 0, Row: 1, Col: 1},
+			wantErr: "must play in sub-board 4",
+		},
+		{
+			name:    "decided sub-board is closed",
+			setup:   func(us *UltimateState) { us.SubResults[2] = GameResultDraw },
+			move:    Move{SubBoard: 2},
+			wantErr: "sub-board already decided",
+		},
+		{
+			name:    "occupied cell",
+			setup:   func(us *UltimateState) { us.SubBoards[3][1][1] = SymbolO },
+			move:    Move{SubBoard: 3, Row: 1, Col: 1},
+			wantErr: "cell already occupied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGame(VariantUltimate)
+			if tt.setup != nil {
+				tt.setup(ultimateState(gs))
+			}
+
+			err := UltimateRules{}.ValidateMove(gs, tt.move)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUltimateApplyMove(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(us *UltimateState)
+		move        Move
+		wantSub     GameResult
+		wantOverall PlayerSymbol
+		wantNext    int
+	}{
+		{
+			name:     "opponent is sent to the sub-board matching the cell",
+			move:     Move{SubBoard: 4, Row: 0, Col: 2},
+			wantSub:  GameResultNone,
+			wantNext: 2,
+		},
+		{
+			name: "completing a line claims the sub-board",
+			setup: func(us *UltimateState) {
+				us.SubBoards[0][0][0] = SymbolX
+				us.SubBoards[0][0][1] = SymbolX
+			},
+			move:        Move{SubBoard: 0, Row: 0, Col: 2},
+			wantSub:     GameResultXWins,
+			wantOverall: SymbolX,
+			wantNext:    2,
+		},
+		{
+			name: "filling a sub-board without a line draws it",
+			setup: func(us *UltimateState) {
+				us.SubBoards[1] = [][]PlayerSymbol{
+					{SymbolX, SymbolO, SymbolX},
+					{SymbolX, SymbolO, SymbolO},
+					{SymbolO, SymbolX, SymbolEmpty},
+				}
+			},
+			move:     Move{SubBoard: 1, Row: 2, Col: 2},
+			wantSub:  GameResultDraw,
+			wantNext: 8,
+		},
+		{
+			name:     "sent to a decided sub-board plays anywhere",
+			setup:    func(us *UltimateState) { us.SubResults[4] = GameResultOWins },
+			move:     Move{SubBoard: 0, Row: 1, Col: 1},
+			wantSub:  GameResultNone,
+			wantNext: AnySubBoard,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGame(VariantUltimate)
+			us := ultimateState(gs)
+			if tt.setup != nil {
+				tt.setup(us)
+			}
+
+			UltimateRules{}.ApplyMove(gs, tt.move)
+
+			if got := us.SubResults[tt.move.SubBoard]; got != tt.wantSub {
+				t.Errorf("sub-board result %s, want %s", got, tt.wantSub)
+			}
+			if got := gs.Board[tt.move.SubBoard/3][tt.move.SubBoard%3]; got != tt.wantOverall {
+				t.Errorf("overall cell %q, want %q", got, tt.wantOverall)
+			}
+			if us.NextSubBoard != tt.wantNext {
+				t.Errorf("next sub-board %d, want %d", us.NextSubBoard, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestUltimateOutcome(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(gs *GameState, us *UltimateState)
+		want  GameResult
+	}{
+		{
+			name: "open sub-boards left",
+			setup: func(gs *GameState, us *UltimateState) {
+				us.SubResults[0] = GameResultXWins
+				gs.Board[0][0] = SymbolX
+			},
+			want: GameResultNone,
+		},
+		{
+			name: "three claimed sub-boards in a row",
+			setup: func(gs *GameState, us *UltimateState) {
+				for i := 0; i < 3; i++ {
+					us.SubResults[i*4] = GameResultXWins
+					gs.Board[i][i] = SymbolX
+				}
+			},
+			want: GameResultXWins,
+		},
+		{
+			name: "every sub-board decided without a line",
+			setup: func(gs *GameState, us *UltimateState) {
+				for i := range us.SubResults {
+					us.SubResults[i] = GameResultDraw
+				}
+			},
+			want: GameResultDraw,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGame(VariantUltimate)
+			tt.setup(gs, ultimateState(gs))
+
+			if got := (UltimateRules{}).Outcome(gs); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUltimatePlayAndStore(t *testing.T) {
+	gs := newTestGame(VariantUltimate)
+	playMoves(t, gs, Move{SubBoard: 4, Row: 1, Col: 1}, Move{SubBoard: 4, Row: 0, Col: 0})
+
+	if gs.Moves[1].Symbol != SymbolO || gs.Moves[1].SubBoard != 4 {
+		t.Fatalf("second move recorded as %+v", gs.Moves[1])
+	}
+
+	data, err := gs.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(data, `"ultimate":{`) {
+		t.Fatalf("sub-boards missing from stored game: %s", data)
+	}
+
+	loaded, err := GameStateFromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := ultimateState(loaded)
+	if us.SubBoards[4][0][0] != SymbolO || us.NextSubBoard != 0 {
+		t.Fatalf("sub-boards not restored: %+v", us)
+	}
+
+	// Clones must not share sub-boards
+	ultimateState(loaded.Clone()).SubBoards[8][2][2] = SymbolX
+	if us.SubBoards[8][2][2] != SymbolEmpty {
+		t.Fatal("clone shares sub-boards with the original")
+	}
+
+	if _, err := GameStateFromJSON(strings.Replace(data, `"ultimate":`, `"other":`, 1)); err == nil {
+		t.Fatal("expected a stored game without sub-boards to be rejected")
+	}
+}
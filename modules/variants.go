@@ -0,0 +1,76 @@
+package main
+
+import "fmt"
+
+// Variant names for the alternative 3x3 rule sets
+const (
+	VariantMisere = "misere" // Completing a line loses
+	VariantWild   = "wild"   // Either player may place either symbol
+)
+
+// MisereRules is played like the standard game, but whoever completes a line loses
+type MisereRules struct {
+	LineRules
+}
+
+// Outcome awards the game to the opponent of a player who completes a line
+func (MisereRules) Outcome(gs *GameState) GameResult {
+	if HasLine(gs.Board, gs.CurrentPlayer, gs.WinLength) {
+		return resultFor(opponentSymbol(gs.CurrentPlayer))
+	}
+	if BoardFull(gs.Board) {
+		return GameResultDraw
+	}
+	return GameResultNone
+}
+
+// WildRules lets each move place either symbol; whoever completes a line of
+// either symbol wins. Players keep their X and O seats for turn order only.
+type WildRules struct {
+	LineRules
+}
+
+// ValidateMove also requires the move to choose a symbol
+func (r WildRules) ValidateMove(gs *GameState, move Move) error {
+	if move.Symbol != SymbolX && move.Symbol != SymbolO {
+		return fmt.Errorf("symbol must be X or O")
+	}
+	return r.LineRules.ValidateMove(gs, move)
+}
+
+// ApplyMove places the symbol chosen by the move
+func (WildRules) ApplyMove(gs *GameState, move Move) {
+	gs.Board[move.Row][move.Col] = move.Symbol
+}
+
+// LegalMoves lists both symbols for every empty cell
+func (r WildRules) LegalMoves(gs *GameState) []Move {
+	cells := r.LineRules.LegalMoves(gs)
+	moves := make([]Move, 0, len(cells)*2)
+	for _, move := range cells {
+		move.Symbol = SymbolX
+		moves = append(moves, move)
+		move.Symbol = SymbolO
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// Outcome awards the game to the player who just moved if any line is complete
+func (WildRules) Outcome(gs *GameState) GameResult {
+	if HasLine(gs.Board, SymbolX, gs.WinLength) || HasLine(gs.Board, SymbolO, gs.WinLength) {
+		return resultFor(gs.CurrentPlayer)
+	}
+	if BoardFull(gs.Board) {
+		return GameResultDraw
+	}
+	return GameResultNone
+}
+
+// opponentSymbol returns the other player's symbol
+func opponentSymbol(symbol PlayerSymbol) PlayerSymbol {
+	if symbol == SymbolX {
+		return SymbolO
+	}
+	return SymbolX
+}
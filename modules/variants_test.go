@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMisereAndWildOutcomes(t *testing.T) {
+	tests := []struct {
+		name       string
+		variant    string
+		moves      []Move
+		wantResult GameResult
+		wantWinner string
+	}{
+		{
+			name:    "misere: completing a line loses",
+			variant: VariantMisere,
+			moves: []Move{
+				{Row: 0, Col: 0}, {Row: 1, Col: 0},
+				{Row: 0, Col: 1}, {Row: 1, Col: 1},
+				{Row: 0, Col: 2},
+			},
+			wantResult: GameResultOWins,
+			wantWinner: "o",
+		},
+		{
+			name:    "misere: O completing a line hands X the game",
+			variant: VariantMisere,
+			moves: []Move{
+				{Row: 2, Col: 2}, {Row: 0, Col: 0},
+				{Row: 2, Col: 0}, {Row: 0, Col: 1},
+				{Row: 1, Col: 2}, {Row: 0, Col: 2},
+			},
+			wantResult: GameResultXWins,
+			wantWinner: "x",
+		},
+		{
+			name:    "misere: full board without a line draws",
+			variant: VariantMisere,
+			moves: []Move{
+				{Row: 0, Col: 0}, {Row: 0, Col: 1}, {Row: 0, Col: 2},
+				{Row: 1, Col: 1}, {Row: 1, Col: 0}, {Row: 1, Col: 2},
+				{Row: 2, Col: 1}, {Row: 2, Col: 0}, {Row: 2, Col: 2},
+			},
+			wantResult: GameResultDraw,
+		},
+		{
+			name:    "wild: completing a line of the opponent's symbol still wins",
+			variant: VariantWild,
+			moves: []Move{
+				{Row: 0, Col: 0, Symbol: SymbolO}, {Row: 2, Col: 2, Symbol: SymbolX},
+				{Row: 0, Col: 1, Symbol: SymbolO}, {Row: 2, Col: 0, Symbol: SymbolX},
+				{Row: 0, Col: 2, Symbol: SymbolO},
+			},
+			wantResult: GameResultXWins,
+			wantWinner: "x",
+		},
+		{
+			name:    "wild: O wins with X's symbol",
+			variant: VariantWild,
+			moves: []Move{
+				{Row: 1, Col: 1, Symbol: SymbolX}, {Row: 0, Col: 0, Symbol: SymbolX},
+				{Row: 2, Col: 0, Symbol: SymbolO}, {Row: 2, Col: 2, Symbol: SymbolX},
+			},
+			wantResult: GameResultOWins,
+			wantWinner: "o",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGame(tt.variant)
+			playMoves(t, gs, tt.moves...)
+
+			if gs.Status != GameStatusFinished || gs.Result != tt.wantResult || gs.Winner != tt.wantWinner {
+				t.Fatalf("got status %s, result %s, winner %q; want result %s, winner %q",
+					gs.Status, gs.Result, gs.Winner, tt.wantResult, tt.wantWinner)
+			}
+		})
+	}
+}
+
+func TestWildMoves(t *testing.T) {
+	gs := newTestGame(VariantWild)
+
+	if moves := (WildRules{}).LegalMoves(gs); len(moves) != 18 {
+		t.Fatalf("expected both symbols for all 9 cells, got %d moves", len(moves))
+	}
+
+	if err := gs.PlayMove(Move{Row: 1, Col: 1}, "x", time.Now(), 0); err == nil || err.Error() != "symbol must be X or O" {
+		t.Fatalf("expected a move without a symbol to be rejected, got %v", err)
+	}
+
+	playMoves(t, gs, Move{Row: 1, Col: 1, Symbol: SymbolO})
+	if gs.Board[1][1] != SymbolO || gs.Moves[0].Symbol != SymbolO || gs.CurrentPlayer != SymbolO {
+		t.Fatalf("X's seat should place O and pass the turn, got board %v, move %+v", gs.Board, gs.Moves[0])
+	}
+}